@@ -0,0 +1,472 @@
+// Package client calls the token service over HTTP.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cache/internal/entity"
+
+	"github.com/go-kit/kit/endpoint"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// Client has one typed method per route of the token service. Failed calls
+// are retried with exponential backoff while the service is unreachable or
+// unavailable, except for Generate and SetToken, which are only retried when
+// the request could not be sent.
+type Client struct {
+	generate    endpoint.Endpoint
+	extract     endpoint.Endpoint
+	setToken    endpoint.Endpoint
+	deleteToken endpoint.Endpoint
+	checkToken  endpoint.Endpoint
+}
+
+// Token is a token issued by Generate.
+type Token struct {
+	Token        string
+	RefreshToken string
+	IssuedAt     int64
+	ExpiresAt    int64
+}
+
+// Claims are the claims of a token read by Extract.
+type Claims struct {
+	Username  string
+	Email     string
+	ID        int
+	IssuedAt  int64
+	ExpiresAt int64
+}
+
+// Session is a session evicted by SetToken to make room for a token.
+type Session struct {
+	ID        string
+	Type      string
+	UserAgent string
+	IP        string
+	IssuedAt  int64
+	ExpiresAt int64
+}
+
+// ResponseError is a failure reported by the service. It unwraps to the
+// sentinel error of its Code, so callers can test it with errors.Is.
+type ResponseError struct {
+	Code      string
+	Message   string
+	RequestID string
+	Status    int
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+type options struct {
+	httpClient *http.Client
+	timeout    time.Duration
+	backoff    time.Duration
+	attempts   int
+}
+
+const (
+	defaultTimeout  = 10 * time.Second
+	defaultBackoff  = 100 * time.Millisecond
+	defaultAttempts = 3
+)
+
+var (
+	ErrInvalidRequest      = errors.New("invalid request")
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrTokenExpired        = errors.New("token is expired")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrForbidden           = errors.New("forbidden")
+	ErrTooManySessions     = errors.New("too many active sessions")
+	ErrConflict            = errors.New("concurrent update")
+	ErrUnavailable         = errors.New("service unavailable")
+	ErrInternal            = errors.New("internal error")
+
+	// codeErrors maps the codes of entity.ErrorEnvelope to their sentinel.
+	codeErrors = map[string]error{
+		entity.CodeInvalidRequest:      ErrInvalidRequest,
+		entity.CodeInvalidToken:        ErrInvalidToken,
+		entity.CodeTokenExpired:        ErrTokenExpired,
+		entity.CodeInvalidRefreshToken: ErrInvalidRefreshToken,
+		entity.CodeForbidden:           ErrForbidden,
+		entity.CodeTooManySessions:     ErrTooManySessions,
+		entity.CodeConflict:            ErrConflict,
+		entity.CodeUnavailable:         ErrUnavailable,
+		entity.CodeInternal:            ErrInternal,
+	}
+
+	// messageCodes maps the messages of the errors the service used to
+	// report in the Err field of a 200 response to a code, the first match
+	// winning.
+	messageCodes = []struct {
+		message string
+		code    string
+	}{
+		{"store is unavailable", entity.CodeUnavailable},
+		{"store is closed", entity.CodeUnavailable},
+		{"concurrent update", entity.CodeConflict},
+		{"failed to decode request", entity.CodeInvalidRequest},
+		{"error to request", entity.CodeInvalidRequest},
+		{"invalid ttl", entity.CodeInvalidRequest},
+		{"invalid tenant", entity.CodeInvalidRequest},
+		{"token is expired", entity.CodeTokenExpired},
+		{"refresh token", entity.CodeInvalidRefreshToken},
+		{"error to claims", entity.CodeInvalidToken},
+		{"unexpected signing method", entity.CodeInvalidToken},
+		{"unknown key id", entity.CodeInvalidToken},
+		{"token is not valid yet", entity.CodeInvalidToken},
+		{"wrong token type", entity.CodeInvalidToken},
+		{"token belongs to another tenant", entity.CodeInvalidToken},
+		{"no secret to verify token", entity.CodeInvalidToken},
+		{"error to extract token", entity.CodeInvalidToken},
+		{"client supplied secrets are not allowed", entity.CodeForbidden},
+		{"token is not active", entity.CodeForbidden},
+		{"too many active sessions", entity.CodeTooManySessions},
+	}
+)
+
+// WithTimeout bounds every attempt of a call, 10s by default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithRetries makes up to attempts attempts per call, the first one
+// included, sleeping backoff before the second and twice as long before
+// each next one. The default is 3 attempts and 100ms; 1 attempt disables
+// retries. Generate and SetToken only retry when the service could not be
+// reached.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithHTTPClient sends the requests with httpClient, whose Timeout then
+// replaces the WithTimeout one.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = httpClient
+	}
+}
+
+// New returns a Client of the token service at baseURL, e.g.
+// "http://localhost:9090".
+func New(baseURL string, opts ...Option) (c *Client, err error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error to parse base url: %w", err)
+	}
+
+	o := options{timeout: defaultTimeout, backoff: defaultBackoff, attempts: defaultAttempts}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.httpClient == nil {
+		o.httpClient = &http.Client{Timeout: o.timeout}
+	}
+
+	// Generate and SetToken are not idempotent: a retried Generate starts
+	// another refresh token family and a retried SetToken may evict sessions.
+	newEndpoint := func(method, path string, dec httptransport.DecodeResponseFunc, idempotent bool) endpoint.Endpoint {
+		target := *base
+		target.Path = strings.TrimSuffix(base.Path, "/") + path
+
+		retryIf := notSent
+		if idempotent {
+			retryIf = retryable
+		}
+
+		return retry(o.attempts, o.backoff, retryIf)(httptransport.NewClient(
+			method,
+			&target,
+			httptransport.EncodeJSONRequest,
+			dec,
+			httptransport.SetClient(o.httpClient),
+		).Endpoint())
+	}
+
+	return &Client{
+		generate: newEndpoint(http.MethodPost, "/generate",
+			decodeResponse(entity.TokenIssuedAtExpiresAtErrResponse{}), false),
+		extract: newEndpoint(http.MethodPost, "/extract",
+			decodeResponse(entity.IDUsernameEmailErrResponse{}), true),
+		setToken: newEndpoint(http.MethodPost, "/token",
+			decodeResponse(entity.EvictedErrResponse{}), false),
+		deleteToken: newEndpoint(http.MethodDelete, "/token",
+			decodeResponse(entity.ErrorResponse{}), true),
+		checkToken: newEndpoint(http.MethodPost, "/check",
+			decodeResponse(entity.CheckErrResponse{}), true),
+	}, nil
+}
+
+// Generate issues a token, and a refresh token if the service has a keyring.
+func (c *Client) Generate(ctx context.Context, id int, username, email string) (token Token, err error) {
+	response, err := c.generate(ctx, entity.IDUsernameEmailSecretRequest{ID: id, Username: username, Email: email})
+	if err != nil {
+		return Token{}, err
+	}
+
+	resp, _ := response.(entity.TokenIssuedAtExpiresAtErrResponse)
+	if resp.Err != "" {
+		return Token{}, messageError(resp.Err)
+	}
+
+	return Token{
+		Token:        resp.Token,
+		RefreshToken: resp.RefreshToken,
+		IssuedAt:     resp.IssuedAt,
+		ExpiresAt:    resp.ExpiresAt,
+	}, nil
+}
+
+// Extract verifies token and returns its claims.
+func (c *Client) Extract(ctx context.Context, token string) (claims Claims, err error) {
+	response, err := c.extract(ctx, entity.TokenSecretRequest{Token: token})
+	if err != nil {
+		return Claims{}, err
+	}
+
+	resp, _ := response.(entity.IDUsernameEmailErrResponse)
+	if resp.Err != "" {
+		return Claims{}, messageError(resp.Err)
+	}
+
+	return Claims{
+		ID:        resp.ID,
+		Username:  resp.Username,
+		Email:     resp.Email,
+		IssuedAt:  resp.IssuedAt,
+		ExpiresAt: resp.ExpiresAt,
+	}, nil
+}
+
+// SetToken whitelists token for ttl, rounded down to seconds, or until its
+// exp claim if ttl is 0. It returns the sessions evicted to make room for it.
+func (c *Client) SetToken(ctx context.Context, token string, ttl time.Duration) (evicted []Session, err error) {
+	response, err := c.setToken(ctx, entity.Token{Token: token, TTL: int64(ttl / time.Second)})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, _ := response.(entity.EvictedErrResponse)
+	if resp.Err != "" {
+		return nil, messageError(resp.Err)
+	}
+
+	for _, session := range resp.Evicted {
+		evicted = append(evicted, Session{
+			ID:        session.ID,
+			Type:      session.Type,
+			UserAgent: session.UserAgent,
+			IP:        session.IP,
+			IssuedAt:  session.IssuedAt,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+
+	return evicted, nil
+}
+
+// DeleteToken revokes token.
+func (c *Client) DeleteToken(ctx context.Context, token string) (err error) {
+	response, err := c.deleteToken(ctx, entity.Token{Token: token})
+	if err != nil {
+		return err
+	}
+
+	if resp, _ := response.(entity.ErrorResponse); resp.Err != "" {
+		return messageError(resp.Err)
+	}
+
+	return nil
+}
+
+// CheckToken reports whether token is whitelisted.
+func (c *Client) CheckToken(ctx context.Context, token string) (check bool, err error) {
+	response, err := c.checkToken(ctx, entity.Token{Token: token})
+	if err != nil {
+		return false, err
+	}
+
+	resp, _ := response.(entity.CheckErrResponse)
+	if resp.Err != "" {
+		return false, messageError(resp.Err)
+	}
+
+	return resp.Check, nil
+}
+
+func (e *ResponseError) Error() string {
+	if e.RequestID == "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+
+	return fmt.Sprintf("%s: %s (request %s)", e.Code, e.Message, e.RequestID)
+}
+
+func (e *ResponseError) Unwrap() error {
+	if err, ok := codeErrors[e.Code]; ok {
+		return err
+	}
+
+	return ErrInternal
+}
+
+// decodeResponse decodes a 2xx response into response, and any other one
+// into a *ResponseError.
+func decodeResponse[resp entity.TokenIssuedAtExpiresAtErrResponse |
+	entity.IDUsernameEmailErrResponse |
+	entity.EvictedErrResponse |
+	entity.ErrorResponse |
+	entity.CheckErrResponse](response resp,
+) httptransport.DecodeResponseFunc {
+	return func(_ context.Context, r *http.Response) (any, error) {
+		if r.StatusCode < http.StatusOK || r.StatusCode >= http.StatusMultipleChoices {
+			return nil, responseError(r)
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+
+		return response, nil
+	}
+}
+
+// responseError reads the entity.ErrorEnvelope of r, falling back on its
+// status for responses that have none, such as those of a proxy.
+func responseError(r *http.Response) *ResponseError {
+	var envelope entity.ErrorEnvelope
+
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil || envelope.Code == "" {
+		envelope = entity.ErrorEnvelope{Code: statusCode(r.StatusCode), Message: http.StatusText(r.StatusCode)}
+	}
+
+	if envelope.RequestID == "" {
+		envelope.RequestID = r.Header.Get("X-Request-ID")
+	}
+
+	return &ResponseError{
+		Code:      envelope.Code,
+		Message:   envelope.Message,
+		RequestID: envelope.RequestID,
+		Status:    r.StatusCode,
+	}
+}
+
+// messageError maps the Err message of a 200 response to a *ResponseError.
+func messageError(message string) *ResponseError {
+	code := entity.CodeInternal
+
+	for _, mapping := range messageCodes {
+		if strings.Contains(message, mapping.message) {
+			code = mapping.code
+
+			break
+		}
+	}
+
+	return &ResponseError{Code: code, Message: message, Status: http.StatusOK}
+}
+
+func statusCode(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return entity.CodeInvalidToken
+	case status == http.StatusForbidden:
+		return entity.CodeForbidden
+	case status == http.StatusConflict:
+		return entity.CodeConflict
+	case status == http.StatusTooManyRequests,
+		status == http.StatusBadGateway,
+		status == http.StatusServiceUnavailable,
+		status == http.StatusGatewayTimeout:
+		return entity.CodeUnavailable
+	case status >= http.StatusBadRequest && status < http.StatusInternalServerError:
+		return entity.CodeInvalidRequest
+	}
+
+	return entity.CodeInternal
+}
+
+// retry calls next up to attempts times while it fails with an error
+// retryable says may go away, sleeping backoff in between, doubled after
+// every attempt.
+func retry(attempts int, backoff time.Duration, retryable func(error) bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (response any, err error) {
+			for attempt := 1; ; attempt++ {
+				response, err = next(ctx, request)
+				if err == nil || attempt >= attempts || ctx.Err() != nil || !retryable(err) {
+					return response, err
+				}
+
+				timer := time.NewTimer(backoff << (attempt - 1))
+
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+
+					return nil, err
+				case <-timer.C:
+				}
+			}
+		}
+	}
+}
+
+// retryable reports whether an idempotent call failing with err may succeed
+// if made again: the service being unreachable, slow to answer or
+// unavailable. Permanent failures, such as TLS, DNS or URL errors, are not
+// retried.
+func retryable(err error) bool {
+	if errors.Is(err, ErrUnavailable) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	if unknownHost(err) {
+		return false
+	}
+
+	var (
+		netErr net.Error
+		opErr  *net.OpError
+	)
+
+	if errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "read" || opErr.Op == "write") {
+		return true
+	}
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// notSent reports whether err happened before the request reached the
+// service, the only failures a call that is not idempotent is retried on.
+func notSent(err error) bool {
+	var opErr *net.OpError
+
+	return errors.As(err, &opErr) && opErr.Op == "dial" && !unknownHost(err)
+}
+
+func unknownHost(err error) bool {
+	var dnsErr *net.DNSError
+
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
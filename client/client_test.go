@@ -0,0 +1,409 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cache/client"
+	"cache/internal/endpoint"
+	"cache/internal/entity"
+	"cache/internal/entity/mock"
+	"cache/internal/service"
+	"cache/internal/store"
+	"cache/internal/transport"
+
+	"github.com/alicebob/miniredis/v2"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-redis/redis"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// newServer serves the token routes the client calls, as cmd does.
+func newServer(t *testing.T) (*httptest.Server, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(mr.Close)
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	svc := service.GetService(
+		store.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()})),
+		service.WithSigningKeys(key),
+	)
+
+	opts := []httptransport.ServerOption{
+		httptransport.ServerBefore(transport.PopulateRequestID),
+		httptransport.ServerErrorEncoder(transport.ErrorEncoder),
+	}
+
+	r := mux.NewRouter()
+	r.Methods(http.MethodPost).Path("/generate").Handler(httptransport.NewServer(
+		endpoint.MakeGenerateTokenEndpoint(svc),
+		transport.DecodeRequest(entity.IDUsernameEmailSecretRequest{}),
+		transport.EncodeResponse,
+		opts...,
+	))
+	r.Methods(http.MethodPost).Path("/extract").Handler(httptransport.NewServer(
+		endpoint.MakeExtractTokenEndpoint(svc),
+		transport.DecodeTokenSecretRequest(""),
+		transport.EncodeResponse,
+		opts...,
+	))
+	r.Methods(http.MethodPost).Path("/token").Handler(httptransport.NewServer(
+		endpoint.MakeStoreTokenEndpoint(svc),
+		transport.DecodeTokenRequest(""),
+		transport.EncodeResponse,
+		opts...,
+	))
+	r.Methods(http.MethodDelete).Path("/token").Handler(httptransport.NewServer(
+		endpoint.MakeManageTokenEndpoint(svc, service.NewDeleteTokenState()),
+		transport.DecodeTokenRequest(""),
+		transport.EncodeResponse,
+		opts...,
+	))
+	r.Methods(http.MethodPost).Path("/check").Handler(httptransport.NewServer(
+		endpoint.MakeCheckTokenEndpoint(svc),
+		transport.DecodeTokenRequest(""),
+		transport.EncodeResponse,
+		opts...,
+	))
+
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	return server, mr
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	server, mr := newServer(t)
+
+	c, err := client.New(server.URL)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	ctx := context.Background()
+
+	token, err := c.Generate(ctx, mock.IDTest, mock.UsernameTest, mock.EmailTest)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	assert.NotEmpty(t, token.Token)
+	assert.NotEmpty(t, token.RefreshToken)
+	assert.Greater(t, token.ExpiresAt, token.IssuedAt)
+
+	claims, err := c.Extract(ctx, token.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, client.Claims{
+		ID:        mock.IDTest,
+		Username:  mock.UsernameTest,
+		Email:     mock.EmailTest,
+		IssuedAt:  token.IssuedAt,
+		ExpiresAt: token.ExpiresAt,
+	}, claims)
+
+	check, err := c.CheckToken(ctx, token.Token)
+	assert.NoError(t, err)
+	assert.False(t, check)
+
+	evicted, err := c.SetToken(ctx, token.Token, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, evicted)
+	assert.Equal(t, time.Minute, mr.TTL(token.Token))
+
+	check, err = c.CheckToken(ctx, token.Token)
+	assert.NoError(t, err)
+	assert.True(t, check)
+
+	assert.NoError(t, c.DeleteToken(ctx, token.Token))
+
+	check, err = c.CheckToken(ctx, token.Token)
+	assert.NoError(t, err)
+	assert.False(t, check)
+}
+
+func TestClientErrors(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newServer(t)
+
+	c, err := client.New(server.URL)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	token, err := c.Generate(context.Background(), mock.IDTest, mock.UsernameTest, mock.EmailTest)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	for _, tt := range []struct {
+		call      func(ctx context.Context) error
+		name      string
+		outErr    error
+		outStatus int
+	}{
+		{
+			name: "InvalidToken",
+			call: func(ctx context.Context) error {
+				_, callErr := c.Extract(ctx, "a.b.c")
+
+				return callErr
+			},
+			outErr:    client.ErrInvalidToken,
+			outStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "InvalidTTL",
+			call: func(ctx context.Context) error {
+				_, callErr := c.SetToken(ctx, token.Token, -time.Second)
+
+				return callErr
+			},
+			outErr:    client.ErrInvalidRequest,
+			outStatus: http.StatusBadRequest,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.call(context.Background())
+			assert.ErrorIs(t, err, tt.outErr)
+
+			var respErr *client.ResponseError
+			if assert.ErrorAs(t, err, &respErr) {
+				assert.Equal(t, tt.outStatus, respErr.Status)
+				assert.NotEmpty(t, respErr.Message)
+				assert.NotEmpty(t, respErr.RequestID)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name        string
+		inStatuses  []int
+		inAttempts  int
+		outErr      error
+		outRequests int32
+	}{
+		{
+			name:        mock.NameNoError,
+			inStatuses:  []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			inAttempts:  3,
+			outRequests: 3,
+		},
+		{
+			name:        "ErrorUnavailable",
+			inStatuses:  []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			inAttempts:  3,
+			outErr:      client.ErrUnavailable,
+			outRequests: 3,
+		},
+		{
+			name:        "ErrorNotRetried",
+			inStatuses:  []int{http.StatusUnauthorized, http.StatusOK},
+			inAttempts:  3,
+			outErr:      client.ErrInvalidToken,
+			outRequests: 1,
+		},
+		{
+			name:        "ErrorNoRetries",
+			inStatuses:  []int{http.StatusServiceUnavailable, http.StatusOK},
+			inAttempts:  1,
+			outErr:      client.ErrUnavailable,
+			outRequests: 1,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests int32
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.inStatuses[atomic.AddInt32(&requests, 1)-1]
+				if status != http.StatusOK {
+					// A proxy in front of the service answers without an envelope.
+					http.Error(w, http.StatusText(status), status)
+
+					return
+				}
+
+				_ = transport.EncodeResponse(r.Context(), w, entity.CheckErrResponse{Check: true})
+			}))
+			defer server.Close()
+
+			c, err := client.New(server.URL, client.WithRetries(tt.inAttempts, time.Millisecond))
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			check, err := c.CheckToken(context.Background(), mock.TokenTest)
+			if tt.outErr == nil {
+				assert.NoError(t, err)
+				assert.True(t, check)
+			} else {
+				assert.ErrorIs(t, err, tt.outErr)
+			}
+
+			assert.Equal(t, tt.outRequests, atomic.LoadInt32(&requests))
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	c, err := client.New(server.URL,
+		client.WithTimeout(20*time.Millisecond),
+		client.WithRetries(2, time.Millisecond),
+	)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	_, err = c.CheckToken(context.Background(), mock.TokenTest)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// A canceled context is not retried.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	c, err = client.New(server.URL, client.WithRetries(3, time.Millisecond))
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	_, err = c.CheckToken(ctx, mock.TokenTest)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestClientRetriesNotIdempotent(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		if r.URL.Path == "/generate" {
+			// Answer too late: the token may have been issued.
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c, err := client.New(server.URL,
+		client.WithTimeout(20*time.Millisecond),
+		client.WithRetries(3, time.Millisecond),
+	)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	_, err = c.SetToken(context.Background(), mock.TokenTest, 0)
+	assert.ErrorIs(t, err, client.ErrUnavailable)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, err = c.Generate(context.Background(), mock.IDTest, mock.UsernameTest, mock.EmailTest)
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// Nothing reaches a closed server, so the call is retried.
+	server.Close()
+
+	c, err = client.New(server.URL, client.WithRetries(2, 50*time.Millisecond))
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	start := time.Now()
+
+	_, err = c.Generate(context.Background(), mock.IDTest, mock.UsernameTest, mock.EmailTest)
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestClientErrMessage(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		inResponse any
+		name       string
+		outErr     error
+	}{
+		{
+			name:       "TokenExpired",
+			inResponse: entity.CheckErrResponse{Err: "error to extract token: token is expired: expired at 2022-01-01T00:00:00Z"},
+			outErr:     client.ErrTokenExpired,
+		},
+		{
+			name:       "Unavailable",
+			inResponse: entity.CheckErrResponse{Err: "error to get token: store is unavailable: connection refused"},
+			outErr:     client.ErrUnavailable,
+		},
+		{
+			name:       "Unknown",
+			inResponse: entity.CheckErrResponse{Err: "something else"},
+			outErr:     client.ErrInternal,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// Services that predate the error envelope answer 200 with Err.
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = transport.EncodeResponse(r.Context(), w, tt.inResponse)
+			}))
+			defer server.Close()
+
+			c, err := client.New(server.URL)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			_, err = c.CheckToken(context.Background(), mock.TokenTest)
+			assert.ErrorIs(t, err, tt.outErr)
+		})
+	}
+}
@@ -3,24 +3,46 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	DBDriver = "postgres"
 )
 
+// Token stores selectable with TOKEN_STORE.
+const (
+	StoreRedis    = "redis"
+	StoreMemory   = "memory"
+	StoreBolt     = "bolt"
+	StorePostgres = DBDriver
+)
+
+const (
+	DefaultBoltStorePath = "tokens.db"
+)
+
 func VerifyIsDockerRun() (check bool) {
 	isDocker := os.Getenv("DOCKER")
 
 	return isDocker == "true"
 }
 
+// LoadEnv sets the development defaults used outside Docker. It sets no
+// signing key: JWT_SECRET, or another key source, must still be given.
 func LoadEnv() (err error) {
 	err = os.Setenv("PORT", "9090")
 	if err != nil {
 		return fmt.Errorf("error to set env:%w", err)
 	}
 
+	err = os.Setenv("GRPC_PORT", "9091")
+	if err != nil {
+		return fmt.Errorf("error to set env:%w", err)
+	}
+
 	err = os.Setenv("REDIS_HOST", "localhost")
 	if err != nil {
 		return fmt.Errorf("error to set env:%w", err)
@@ -31,5 +53,76 @@ func LoadEnv() (err error) {
 		return fmt.Errorf("error to set env:%w", err)
 	}
 
+	err = os.Setenv("TOKEN_LIFETIME", "10m")
+	if err != nil {
+		return fmt.Errorf("error to set env:%w", err)
+	}
+
+	err = os.Setenv("TOKEN_LEEWAY", "30s")
+	if err != nil {
+		return fmt.Errorf("error to set env:%w", err)
+	}
+
 	return nil
 }
+
+// GetDuration parses the env var key as a time.Duration, returning 0 if it is unset.
+func GetDuration(key string) (d time.Duration, err error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err = time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("error to parse env %s:%w", key, err)
+	}
+
+	return d, nil
+}
+
+// GetInt parses the env var key as an int, returning 0 if it is unset.
+func GetInt(key string) (n int, err error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err = strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("error to parse env %s:%w", key, err)
+	}
+
+	return n, nil
+}
+
+// GetList splits the comma separated env var key, skipping empty items.
+func GetList(key string) (list []string) {
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// GetSecret returns the env var key or, when it is unset, the trimmed
+// contents of the file named by key+"_FILE".
+func GetSecret(key string) (secret []byte, err error) {
+	if value := os.Getenv(key); value != "" {
+		return []byte(value), nil
+	}
+
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	secret, err = os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error to read %s_FILE:%w", key, err)
+	}
+
+	return []byte(strings.TrimSpace(string(secret))), nil
+}
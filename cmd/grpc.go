@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"cache/internal/pb"
+	"cache/internal/service"
+	"cache/internal/transport"
+
+	kittransport "github.com/go-kit/kit/transport"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"google.golang.org/grpc"
+)
+
+// serveGRPC serves the token endpoints of svc over gRPC on listener, reading
+// the tenant from the tenantHeader metadata, and exits if serving fails.
+func serveGRPC(listener net.Listener, svc service.Service, tenantHeader string) {
+	server := grpc.NewServer()
+
+	pb.RegisterTokenServer(server, transport.NewGRPCServer(svc,
+		grpctransport.ServerBefore(
+			transport.RequestIDFromMetadata,
+			transport.TenantFromMetadata(strings.ToLower(tenantHeader)),
+			transport.ClientFromMetadata,
+		),
+		grpctransport.ServerErrorHandler(kittransport.ErrorHandlerFunc(logServerError)),
+	))
+
+	log.Fatal(server.Serve(listener))
+}
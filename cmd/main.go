@@ -1,22 +1,46 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"cache/cmd/config"
 	"cache/internal/endpoint"
 	"cache/internal/entity"
 	"cache/internal/service"
+	"cache/internal/store"
 	"cache/internal/transport"
 
+	kittransport "github.com/go-kit/kit/transport"
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 )
 
+const (
+	defaultJWKSMaxAge       = 5 * time.Minute
+	defaultKeyRotationGrace = 10 * time.Minute
+	defaultNearCacheSize    = 10000
+	defaultNearCacheChannel = "cache:invalidate"
+	defaultTenantHeader     = "X-Tenant-ID"
+)
+
+var (
+	errUnknownStore         = errors.New("unknown TOKEN_STORE")
+	errUnknownSessionPolicy = errors.New("unknown MAX_SESSIONS_POLICY")
+	errNoSigningKey         = errors.New(
+		"no signing key: set JWT_SECRET, JWT_SECRET_FILE or SIGNING_KEY_FILES, " +
+			"or ALLOW_CLIENT_SECRETS=true for the legacy mode",
+	)
+)
+
 func main() {
 	if !config.VerifyIsDockerRun() {
 		if err := config.LoadEnv(); err != nil {
@@ -24,49 +48,511 @@ func main() {
 		}
 	}
 
-	fmt.Println(os.Getenv("REDIS_HOST"))
+	db, invalidator, err := openStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Keys are shared through the store itself, not its near cache. An
+	// in-process store has no replica to share them with and may evict them.
+	var keyStore store.TokenStore
+
+	if os.Getenv("TOKEN_STORE") != config.StoreMemory {
+		keyStore = db
+
+		if prefix := os.Getenv("KEY_PREFIX"); prefix != "" {
+			keyStore = store.NewNamespace(db, prefix)
+		}
+	}
+
+	if db, err = nearCache(db, invalidator); err != nil {
+		log.Fatal(err)
+	}
+
+	jwksMaxAge, err := config.GetDuration("JWKS_MAX_AGE")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if jwksMaxAge == 0 {
+		jwksMaxAge = defaultJWKSMaxAge
+	}
 
-	options := &redis.Options{
-		Addr:     os.Getenv("REDIS_HOST") + ":" + os.Getenv("REDIS_PORT"),
-		Password: "",
-		DB:       0,
+	opts, err := serviceOptions(keyStore, jwksMaxAge)
+	if err != nil {
+		log.Fatal(err)
 	}
-	db := redis.NewClient(options)
 
-	runServer(os.Getenv("PORT"), db)
+	runServer(os.Getenv("PORT"), db, jwksMaxAge, opts...)
 }
 
-func runServer(port string, db *redis.Client) {
-	svc := service.GetService(db)
+// openStore opens the token store named by TOKEN_STORE, Redis by default.
+// For Redis it also returns an Invalidator over its pub/sub.
+func openStore() (db store.TokenStore, invalidator store.Invalidator, err error) {
+	switch name := os.Getenv("TOKEN_STORE"); name {
+	case "", config.StoreRedis:
+		var (
+			opts   store.RedisOptions
+			client redis.UniversalClient
+		)
+
+		if opts, err = redisOptions(); err != nil {
+			return nil, nil, err
+		}
+
+		if client, err = store.NewRedisClient(opts); err != nil {
+			return nil, nil, err
+		}
+
+		channel := os.Getenv("NEAR_CACHE_CHANNEL")
+		if channel == "" {
+			channel = defaultNearCacheChannel
+		}
+
+		return store.NewRedisStore(client), store.NewRedisInvalidator(client, channel), nil
+
+	case config.StoreMemory:
+		var maxEntries int
+
+		if maxEntries, err = config.GetInt("MEMORY_STORE_MAX_ENTRIES"); err != nil {
+			return nil, nil, err
+		}
+
+		return store.NewMemoryStore(store.WithMaxEntries(maxEntries)), nil, nil
+
+	case config.StoreBolt:
+		path := os.Getenv("BOLT_STORE_PATH")
+		if path == "" {
+			path = config.DefaultBoltStorePath
+		}
+
+		db, err = store.NewBoltStore(path)
+
+		return db, nil, err
+
+	case config.StorePostgres:
+		var conn *sql.DB
+
+		if conn, err = sql.Open(config.DBDriver, os.Getenv("POSTGRES_DSN")); err != nil {
+			return nil, nil, err
+		}
+
+		db, err = store.NewPostgresStore(conn)
+
+		return db, nil, err
+
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", errUnknownStore, name)
+	}
+}
+
+// nearCache puts an in-process cache in front of db when NEAR_CACHE_TTL is
+// set, invalidated across replicas by invalidator if there is one.
+func nearCache(db store.TokenStore, invalidator store.Invalidator) (cached store.TokenStore, err error) {
+	ttl, err := config.GetDuration("NEAR_CACHE_TTL")
+	if err != nil || ttl == 0 {
+		return db, err
+	}
+
+	negativeTTL, err := config.GetDuration("NEAR_CACHE_NEGATIVE_TTL")
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := config.GetInt("NEAR_CACHE_SIZE")
+	if err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		size = defaultNearCacheSize
+	}
+
+	opts := []store.Option{store.WithNegativeTTL(negativeTTL), store.WithMaxEntries(size)}
+
+	if invalidator != nil {
+		opts = append(opts, store.WithInvalidator(invalidator))
+	} else {
+		log.Println("NEAR_CACHE_TTL set without Redis: revocations on other replicas apply after", ttl)
+	}
+
+	return store.NewNearCache(db, ttl, opts...)
+}
+
+func serviceOptions(keyStore store.TokenStore, jwksMaxAge time.Duration) (opts []service.Option, err error) {
+	lifetime, err := config.GetDuration("TOKEN_LIFETIME")
+	if err != nil {
+		return nil, err
+	}
+
+	if lifetime != 0 {
+		opts = append(opts, service.WithTokenLifetime(lifetime))
+	}
+
+	leeway, err := config.GetDuration("TOKEN_LEEWAY")
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, service.WithLeeway(leeway))
+
+	sessionOpts, err := sessionOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, sessionOpts...)
+
+	if prefix := os.Getenv("KEY_PREFIX"); prefix != "" {
+		opts = append(opts, service.WithKeyPrefix(prefix))
+	}
+
+	minTTL, err := config.GetDuration("TOKEN_TTL_MIN")
+	if err != nil {
+		return nil, err
+	}
+
+	maxTTL, err := config.GetDuration("TOKEN_TTL_MAX")
+	if err != nil {
+		return nil, err
+	}
+
+	if minTTL != 0 || maxTTL != 0 {
+		opts = append(opts, service.WithTokenTTLBounds(minTTL, maxTTL))
+	}
+
+	refreshLifetime, err := config.GetDuration("REFRESH_TOKEN_LIFETIME")
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshLifetime != 0 {
+		opts = append(opts, service.WithRefreshTokenLifetime(refreshLifetime))
+	}
+
+	keyringOpts, err := keyringOptions(keyStore, lifetime, jwksMaxAge)
+	if err != nil {
+		return nil, err
+	}
+
+	allowClientSecrets := os.Getenv("ALLOW_CLIENT_SECRETS") == "true"
+
+	if len(keyringOpts) == 0 && !allowClientSecrets {
+		return nil, errNoSigningKey
+	}
+
+	if allowClientSecrets {
+		log.Println("ALLOW_CLIENT_SECRETS=true: accepting secrets from request bodies")
+
+		opts = append(opts, service.WithClientSecrets())
+	}
+
+	pepperOpts, err := tokenPepperOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, pepperOpts...)
+
+	return append(opts, keyringOpts...), nil
+}
+
+// sessionOptions enables sliding expiration when SESSION_IDLE_TIMEOUT is set,
+// bounded by SESSION_ABSOLUTE_TIMEOUT. It also caps the access tokens of a
+// user at MAX_SESSIONS, either rejecting new ones or evicting the oldest as
+// MAX_SESSIONS_POLICY says (reject, the default, or evict_oldest).
+func sessionOptions() (opts []service.Option, err error) {
+	idle, err := config.GetDuration("SESSION_IDLE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+
+	absolute, err := config.GetDuration("SESSION_ABSOLUTE_TIMEOUT")
+	if err != nil {
+		return nil, err
+	}
+
+	if idle != 0 {
+		opts = append(opts, service.WithSlidingExpiration(idle, absolute))
+	}
+
+	maxSessions, err := config.GetInt("MAX_SESSIONS")
+	if err != nil {
+		return nil, err
+	}
+
+	if maxSessions == 0 {
+		return opts, nil
+	}
+
+	policy := service.SessionLimitPolicy(os.Getenv("MAX_SESSIONS_POLICY"))
+
+	switch policy {
+	case "":
+		policy = service.SessionLimitReject
+	case service.SessionLimitReject, service.SessionLimitEvictOldest:
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownSessionPolicy, policy)
+	}
+
+	return append(opts, service.WithMaxSessions(maxSessions, policy)), nil
+}
+
+// tokenPepperOptions stores tokens under their HMAC with TOKEN_PEPPER (or
+// TOKEN_PEPPER_FILE). MIGRATE_RAW_KEYS=true also finds and moves the keys
+// stored raw before the pepper was set.
+func tokenPepperOptions() (opts []service.Option, err error) {
+	pepper, err := config.GetSecret("TOKEN_PEPPER")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pepper) == 0 {
+		log.Println("TOKEN_PEPPER not set: tokens are stored as raw keys")
+
+		return nil, nil
+	}
+
+	opts = append(opts, service.WithTokenPepper(pepper))
+
+	if migrateRawKeys() {
+		opts = append(opts, service.WithRawKeyMigration())
+	}
+
+	return opts, nil
+}
+
+func migrateRawKeys() (migrate bool) {
+	return os.Getenv("MIGRATE_RAW_KEYS") == "true"
+}
+
+// keyringOptions loads JWT_SECRET (or JWT_SECRET_FILE) and SIGNING_KEY_FILES
+// and schedules rotation every KEY_ROTATION_INTERVAL. For development only,
+// SIGNING_ALG generates a key when neither is given. With KEYRING_SECRET (or
+// KEYRING_SECRET_FILE), rotated keys are kept in keyStore, if any, encrypted
+// for every replica, and published jwksMaxAge before they sign.
+func keyringOptions(
+	keyStore store.TokenStore,
+	lifetime, jwksMaxAge time.Duration,
+) (opts []service.Option, err error) {
+	var keys []service.SigningKey
+
+	var key service.SigningKey
+
+	secret, err := config.GetSecret("JWT_SECRET")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(secret) != 0 {
+		if key, err = service.NewSigningKey(secret); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	for _, path := range config.GetList("SIGNING_KEY_FILES") {
+		if key, err = service.LoadSigningKey(path); err != nil {
+			return nil, fmt.Errorf("error to load signing key %s: %w", path, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	if alg := os.Getenv("SIGNING_ALG"); len(keys) == 0 && alg != "" {
+		log.Printf("SIGNING_ALG=%s: signing with a key generated for this process only; "+
+			"its tokens do not survive a restart nor verify on other replicas", alg)
+
+		if key, err = service.GenerateSigningKey(alg); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	grace, err := config.GetDuration("KEY_ROTATION_GRACE")
+	if err != nil {
+		return nil, err
+	}
+
+	if grace == 0 {
+		grace = lifetime
+	}
+
+	if grace == 0 {
+		grace = defaultKeyRotationGrace
+	}
+
+	keyring := service.NewKeyring(grace, keys[0], keys[1:]...)
+
+	if keyStore != nil {
+		if err = shareKeyring(keyring, keyStore, jwksMaxAge); err != nil {
+			return nil, err
+		}
+	}
+
+	interval, err := config.GetDuration("KEY_ROTATION_INTERVAL")
+	if err != nil {
+		return nil, err
+	}
+
+	if interval != 0 {
+		keyring.RotateEvery(interval, func(err error) { log.Println(err) })
+	}
+
+	return []service.Option{service.WithKeyring(keyring)}, nil
+}
+
+// shareKeyring shares the rotated keys of keyring through keyStore when
+// KEYRING_SECRET is set.
+func shareKeyring(keyring *service.Keyring, keyStore store.TokenStore, jwksMaxAge time.Duration) (err error) {
+	secret, err := config.GetSecret("KEYRING_SECRET")
+	if err != nil {
+		return err
+	}
+
+	if len(secret) == 0 {
+		log.Println("KEYRING_SECRET not set: rotated keys are kept by this process only")
+
+		return nil
+	}
+
+	// The keys are loaded again once the store is reachable.
+	err = keyring.Share(keyStore, secret, jwksMaxAge)
+	if errors.Is(err, service.ErrKeyringSecret) {
+		return err
+	}
+
+	if err != nil {
+		log.Println(err)
+	}
+
+	return nil
+}
+
+func runServer(port string, db store.TokenStore, jwksMaxAge time.Duration, opts ...service.Option) {
+	svc := service.GetService(db, opts...)
+
+	tenantHeader := os.Getenv("TENANT_HEADER")
+	if tenantHeader == "" {
+		tenantHeader = defaultTenantHeader
+	}
+
+	serverOpts := []httptransport.ServerOption{
+		httptransport.ServerBefore(
+			transport.PopulateRequestID,
+			transport.TenantFromHeader(tenantHeader),
+			transport.ClientFromRequest(os.Getenv("TRUST_PROXY_HEADERS") == "true"),
+		),
+		httptransport.ServerAfter(transport.SetRequestIDHeader),
+		httptransport.ServerErrorEncoder(transport.ErrorEncoder),
+		httptransport.ServerErrorHandler(kittransport.ErrorHandlerFunc(logServerError)),
+	}
+
+	// The token of /token, /check and /extract may also come from the
+	// Authorization header or from this cookie, taking precedence over the
+	// body. /auth only reads those two.
+	tokenCookie := os.Getenv("TOKEN_COOKIE")
+
+	// Bind the gRPC port before serving, so that a taken port stops the
+	// service rather than leaving it up over HTTP only.
+	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
+		listener, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println("gRPC Serve on localhost:" + grpcPort)
+
+		go serveGRPC(listener, svc, tenantHeader)
+	}
+
+	if migrateRawKeys() {
+		go func() {
+			migrated, err := svc.MigrateRawKeys()
+			if err != nil {
+				log.Println(err)
+
+				return
+			}
+
+			log.Println("migrated", migrated, "raw token keys")
+		}()
+	}
 
 	getGenerateTokenHandler := httptransport.NewServer(
 		endpoint.MakeGenerateTokenEndpoint(svc),
 		transport.DecodeRequest(entity.IDUsernameEmailSecretRequest{}),
 		transport.EncodeResponse,
+		serverOpts...,
 	)
 
 	getExtractTokenHandler := httptransport.NewServer(
 		endpoint.MakeExtractTokenEndpoint(svc),
-		transport.DecodeRequest(entity.TokenSecretRequest{}),
+		transport.DecodeTokenSecretRequest(tokenCookie),
 		transport.EncodeResponse,
+		serverOpts...,
 	)
 
 	getSetTokenHandler := httptransport.NewServer(
-		endpoint.MakeManageTokenEndpoint(svc, service.NewSetTokenState()),
-		transport.DecodeRequest(entity.Token{}),
+		endpoint.MakeStoreTokenEndpoint(svc),
+		transport.DecodeTokenRequest(tokenCookie),
 		transport.EncodeResponse,
+		serverOpts...,
 	)
 
 	getDeleteTokenHandler := httptransport.NewServer(
 		endpoint.MakeManageTokenEndpoint(svc, service.NewDeleteTokenState()),
-		transport.DecodeRequest(entity.Token{}),
+		transport.DecodeTokenRequest(tokenCookie),
 		transport.EncodeResponse,
+		serverOpts...,
 	)
 
 	getCheckTokenHandler := httptransport.NewServer(
 		endpoint.MakeCheckTokenEndpoint(svc),
-		transport.DecodeRequest(entity.Token{}),
+		transport.DecodeTokenRequest(tokenCookie),
 		transport.EncodeResponse,
+		serverOpts...,
+	)
+
+	getAuthHandler := httptransport.NewServer(
+		endpoint.MakeAuthEndpoint(svc),
+		transport.DecodeAuthRequest(tokenCookie),
+		transport.EncodeAuthResponse,
+		serverOpts...,
+	)
+
+	getRefreshTokenHandler := httptransport.NewServer(
+		endpoint.MakeRefreshTokenEndpoint(svc),
+		transport.DecodeRequest(entity.RefreshTokenRequest{}),
+		transport.EncodeResponse,
+		serverOpts...,
+	)
+
+	getIntrospectTokenHandler := httptransport.NewServer(
+		endpoint.MakeIntrospectTokenEndpoint(svc),
+		transport.DecodeFormRequest,
+		transport.EncodeResponse,
+		serverOpts...,
+	)
+
+	getRevokeTokenHandler := httptransport.NewServer(
+		endpoint.MakeRevokeTokenEndpoint(svc),
+		transport.DecodeFormRequest,
+		transport.EncodeResponse,
+		serverOpts...,
+	)
+
+	getJWKSHandler := httptransport.NewServer(
+		endpoint.MakeJWKSEndpoint(svc),
+		httptransport.NopRequestDecoder,
+		transport.EncodeJWKSResponse(jwksMaxAge),
+		serverOpts...,
 	)
 
 	r := mux.NewRouter()
@@ -75,7 +561,60 @@ func runServer(port string, db *redis.Client) {
 	r.Methods(http.MethodPost).Path("/token").Handler(getSetTokenHandler)
 	r.Methods(http.MethodDelete).Path("/token").Handler(getDeleteTokenHandler)
 	r.Methods(http.MethodPost).Path("/check").Handler(getCheckTokenHandler)
+	r.Methods(http.MethodGet).Path("/auth").Handler(getAuthHandler)
+	r.Methods(http.MethodPost).Path("/refresh").Handler(getRefreshTokenHandler)
+	r.Methods(http.MethodGet).Path("/.well-known/jwks.json").Handler(getJWKSHandler)
+
+	// RFC 7662 and RFC 7009 require their callers to authenticate, so the
+	// OAuth endpoints meant for resource servers need INTROSPECTION_TOKEN.
+	if introspectionToken := os.Getenv("INTROSPECTION_TOKEN"); introspectionToken != "" {
+		r.Methods(http.MethodPost).Path("/introspect").
+			Handler(transport.RequireBearerToken(introspectionToken, getIntrospectTokenHandler))
+		r.Methods(http.MethodPost).Path("/revoke").
+			Handler(transport.RequireBearerToken(introspectionToken, getRevokeTokenHandler))
+	} else {
+		log.Println("INTROSPECTION_TOKEN not set: /introspect and /revoke are disabled")
+	}
+
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		getRotateKeysHandler := httptransport.NewServer(
+			endpoint.MakeRotateKeysEndpoint(svc),
+			httptransport.NopRequestDecoder,
+			transport.EncodeResponse,
+			serverOpts...,
+		)
+
+		r.Methods(http.MethodPost).Path("/admin/keys/rotate").
+			Handler(transport.RequireBearerToken(adminToken, getRotateKeysHandler))
+
+		getSessionsHandler := httptransport.NewServer(
+			endpoint.MakeSessionsEndpoint(svc),
+			transport.DecodeUserIDRequest,
+			transport.EncodeResponse,
+			serverOpts...,
+		)
+
+		getRevokeSessionsHandler := httptransport.NewServer(
+			endpoint.MakeRevokeSessionsEndpoint(svc),
+			transport.DecodeUserIDRequest,
+			transport.EncodeResponse,
+			serverOpts...,
+		)
+
+		r.Methods(http.MethodGet).Path("/users/{id}/tokens").
+			Handler(transport.RequireBearerToken(adminToken, getSessionsHandler))
+		r.Methods(http.MethodDelete).Path("/users/{id}/tokens").
+			Handler(transport.RequireBearerToken(adminToken, getRevokeSessionsHandler))
+	}
 
 	log.Println("ListenAndServe on localhost:" + os.Getenv("PORT"))
 	log.Println(http.ListenAndServe(":"+port, r))
 }
+
+// logServerError logs the errors ErrorEncoder hides from clients, under the
+// request id it answers with.
+func logServerError(ctx context.Context, err error) {
+	if status, _ := transport.ErrorStatus(err); status >= http.StatusInternalServerError {
+		log.Printf("request %s: %v", transport.RequestIDFromContext(ctx), err)
+	}
+}
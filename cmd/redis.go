@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"cache/cmd/config"
+	"cache/internal/store"
+)
+
+var errRedisCA = errors.New("no certificate found in REDIS_TLS_CA_FILE")
+
+// redisOptions reads the Redis client configuration. REDIS_MODE selects
+// standalone (REDIS_HOST and REDIS_PORT), sentinel (REDIS_ADDRS lists the
+// Sentinels, REDIS_MASTER_NAME the master) or cluster (REDIS_ADDRS lists the
+// seed nodes).
+func redisOptions() (opts store.RedisOptions, err error) {
+	opts = store.RedisOptions{
+		Mode:       os.Getenv("REDIS_MODE"),
+		MasterName: os.Getenv("REDIS_MASTER_NAME"),
+		Username:   os.Getenv("REDIS_USERNAME"),
+		Addrs:      config.GetList("REDIS_ADDRS"),
+	}
+
+	if len(opts.Addrs) == 0 {
+		opts.Addrs = []string{os.Getenv("REDIS_HOST") + ":" + os.Getenv("REDIS_PORT")}
+	}
+
+	password, err := config.GetSecret("REDIS_PASSWORD")
+	if err != nil {
+		return store.RedisOptions{}, err
+	}
+
+	opts.Password = string(password)
+
+	for key, value := range map[string]*int{
+		"REDIS_DB":             &opts.DB,
+		"REDIS_POOL_SIZE":      &opts.PoolSize,
+		"REDIS_MIN_IDLE_CONNS": &opts.MinIdleConns,
+	} {
+		if *value, err = config.GetInt(key); err != nil {
+			return store.RedisOptions{}, err
+		}
+	}
+
+	if opts.PoolTimeout, err = config.GetDuration("REDIS_POOL_TIMEOUT"); err != nil {
+		return store.RedisOptions{}, err
+	}
+
+	if os.Getenv("REDIS_TLS") == "true" {
+		if opts.TLSConfig, err = redisTLSConfig(); err != nil {
+			return store.RedisOptions{}, err
+		}
+	}
+
+	return opts, nil
+}
+
+// redisTLSConfig trusts the CA in REDIS_TLS_CA_FILE, if set, in addition to
+// the system roots, and checks the server against REDIS_TLS_SERVER_NAME.
+func redisTLSConfig() (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: os.Getenv("REDIS_TLS_SERVER_NAME"),
+	}
+
+	path := os.Getenv("REDIS_TLS_CA_FILE")
+	if path == "" {
+		return tlsConfig, nil
+	}
+
+	ca, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error to read REDIS_TLS_CA_FILE:%w", err)
+	}
+
+	if tlsConfig.RootCAs, err = x509.SystemCertPool(); err != nil {
+		tlsConfig.RootCAs = x509.NewCertPool()
+	}
+
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, errRedisCA
+	}
+
+	return tlsConfig, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"cache/internal/entity"
 	"cache/internal/service"
@@ -11,19 +12,47 @@ import (
 	"github.com/go-kit/kit/endpoint"
 )
 
-var ErrRequest = errors.New("error to request")
+var (
+	// ErrRequest is returned for requests of the wrong type. The endpoints
+	// return the errors of the service too, along with a response carrying
+	// their message in Err, so transports can map them to a status.
+	ErrRequest = errors.New("error to request")
+
+	ErrInactiveToken = errors.New("token is not active")
+)
 
 // MakeGenerateTokenEndpoint ...
 func MakeGenerateTokenEndpoint(svc service.Service) endpoint.Endpoint {
-	return func(_ context.Context, request any) (any, error) {
+	return func(ctx context.Context, request any) (any, error) {
+		var errMessage string
+
 		req, ok := request.(entity.IDUsernameEmailSecretRequest)
 		if !ok {
 			return nil, fmt.Errorf("%w: isn't of type GenerateTokenRequest", ErrRequest)
 		}
 
-		token := svc.GenerateToken(req.ID, req.Username, req.Email, []byte(req.Secret))
+		var refreshToken string
+
+		scopedSvc := scoped(ctx, svc)
+
+		token, issuedAt, expiresAt, err := scopedSvc.GenerateToken(req.ID, req.Username, req.Email, []byte(req.Secret))
+
+		// Refresh tokens need server-held keys, so legacy requests don't get one.
+		if err == nil && req.Secret == "" {
+			refreshToken, err = scopedSvc.GenerateRefreshToken(req.ID, req.Username, req.Email, token)
+		}
+
+		if err != nil {
+			errMessage = err.Error()
+		}
 
-		return entity.Token{Token: token}, nil
+		return entity.TokenIssuedAtExpiresAtErrResponse{
+			Token:        token,
+			RefreshToken: refreshToken,
+			IssuedAt:     issuedAt,
+			ExpiresAt:    expiresAt,
+			Err:          errMessage,
+		}, err
 	}
 }
 
@@ -37,18 +66,25 @@ func MakeExtractTokenEndpoint(svc service.Service) endpoint.Endpoint {
 			return nil, fmt.Errorf("%w: isn't of type GenerateTokenRequest", ErrRequest)
 		}
 
-		id, username, email, err := svc.ExtractToken(req.Token, []byte(req.Secret))
+		claims, err := svc.ExtractToken(req.Token, []byte(req.Secret))
 		if err != nil {
 			errMessage = err.Error()
 		}
 
-		return entity.IDUsernameEmailErrResponse{ID: id, Username: username, Email: email, Err: errMessage}, nil
+		return entity.IDUsernameEmailErrResponse{
+			ID:        claims.ID,
+			Username:  claims.Username,
+			Email:     claims.Email,
+			IssuedAt:  claims.IssuedAt,
+			ExpiresAt: claims.ExpiresAt,
+			Err:       errMessage,
+		}, err
 	}
 }
 
 // MakeManageTokenEndpoint ...
 func MakeManageTokenEndpoint(svc service.Service, st service.State) endpoint.Endpoint {
-	return func(_ context.Context, request any) (any, error) {
+	return func(ctx context.Context, request any) (any, error) {
 		var errMessage string
 
 		req, ok := request.(entity.Token)
@@ -56,18 +92,36 @@ func MakeManageTokenEndpoint(svc service.Service, st service.State) endpoint.End
 			return nil, fmt.Errorf("%w: isn't of type Token", ErrRequest)
 		}
 
-		err := svc.ManageToken(st, req.Token)
+		err := scoped(ctx, svc).ManageToken(st, req.Token)
 		if err != nil {
 			errMessage = err.Error()
 		}
 
-		return entity.ErrorResponse{Err: errMessage}, nil
+		return entity.ErrorResponse{Err: errMessage}, err
+	}
+}
+
+// MakeStoreTokenEndpoint whitelists the token, for the ttl requested in
+// seconds if any, and lists the sessions evicted to make room for it.
+func MakeStoreTokenEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req, ok := request.(entity.Token)
+		if !ok {
+			return nil, fmt.Errorf("%w: isn't of type Token", ErrRequest)
+		}
+
+		evicted, err := scoped(ctx, svc).StoreToken(req.Token, time.Duration(req.TTL)*time.Second)
+		if err != nil {
+			return entity.EvictedErrResponse{Err: err.Error()}, err
+		}
+
+		return entity.EvictedErrResponse{Evicted: sessionsResponse(evicted)}, nil
 	}
 }
 
 // MakeCheckTokenEndpoint ...
 func MakeCheckTokenEndpoint(svc service.Service) endpoint.Endpoint {
-	return func(_ context.Context, request any) (any, error) {
+	return func(ctx context.Context, request any) (any, error) {
 		var errMessage string
 
 		req, ok := request.(entity.Token)
@@ -75,11 +129,224 @@ func MakeCheckTokenEndpoint(svc service.Service) endpoint.Endpoint {
 			return nil, fmt.Errorf("%w: isn't of type Token", ErrRequest)
 		}
 
-		check, err := svc.CheckToken(req.Token)
+		check, err := scoped(ctx, svc).CheckToken(req.Token)
+		if err != nil {
+			errMessage = err.Error()
+		}
+
+		return entity.CheckErrResponse{Check: check, Err: errMessage}, err
+	}
+}
+
+// MakeAuthEndpoint authenticates the token of a forward-auth subrequest,
+// which must verify and be stored. ErrInactiveToken reports one that verifies
+// but was never stored or was revoked since.
+func MakeAuthEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req, ok := request.(entity.Token)
+		if !ok {
+			return nil, fmt.Errorf("%w: isn't of type Token", ErrRequest)
+		}
+
+		scopedSvc := scoped(ctx, svc)
+
+		claims, err := scopedSvc.ExtractToken(req.Token, nil)
+		if err != nil {
+			return entity.IDUsernameEmailErrResponse{Err: err.Error()}, err
+		}
+
+		check, err := scopedSvc.CheckToken(req.Token)
+		if err == nil && !check {
+			err = ErrInactiveToken
+		}
+
+		if err != nil {
+			return entity.IDUsernameEmailErrResponse{Err: err.Error()}, err
+		}
+
+		return entity.IDUsernameEmailErrResponse{
+			ID:        claims.ID,
+			Username:  claims.Username,
+			Email:     claims.Email,
+			IssuedAt:  claims.IssuedAt,
+			ExpiresAt: claims.ExpiresAt,
+		}, nil
+	}
+}
+
+// MakeRefreshTokenEndpoint ...
+func MakeRefreshTokenEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		var errMessage string
+
+		req, ok := request.(entity.RefreshTokenRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: isn't of type RefreshTokenRequest", ErrRequest)
+		}
+
+		pair, err := scoped(ctx, svc).RefreshToken(req.RefreshToken)
 		if err != nil {
 			errMessage = err.Error()
 		}
 
-		return entity.CheckErrResponse{Check: check, Err: errMessage}, nil
+		return entity.TokenIssuedAtExpiresAtErrResponse{
+			Token:        pair.AccessToken,
+			RefreshToken: pair.RefreshToken,
+			IssuedAt:     pair.IssuedAt,
+			ExpiresAt:    pair.ExpiresAt,
+			Err:          errMessage,
+		}, err
 	}
 }
+
+// MakeIntrospectTokenEndpoint ...
+func MakeIntrospectTokenEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req, ok := request.(entity.TokenTypeHintRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: isn't of type TokenTypeHintRequest", ErrRequest)
+		}
+
+		claims, active, err := scoped(ctx, svc).IntrospectToken(req.Token)
+		if err != nil {
+			return entity.IntrospectionResponse{Active: false, Err: err.Error()}, err
+		}
+
+		if !active {
+			return entity.IntrospectionResponse{Active: false}, nil
+		}
+
+		tokenType := "Bearer"
+		if claims.Type != "" {
+			tokenType = claims.Type
+		}
+
+		return entity.IntrospectionResponse{
+			Active:    true,
+			Subject:   claims.Subject,
+			Username:  claims.Username,
+			Email:     claims.Email,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			TokenType: tokenType,
+			TokenID:   claims.TokenID,
+			ExpiresAt: claims.ExpiresAt,
+			IssuedAt:  claims.IssuedAt,
+		}, nil
+	}
+}
+
+// MakeRevokeTokenEndpoint ...
+func MakeRevokeTokenEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		var errMessage string
+
+		req, ok := request.(entity.TokenTypeHintRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: isn't of type TokenTypeHintRequest", ErrRequest)
+		}
+
+		// The hint is optional (RFC 7009 section 2.1): DeleteTokenState
+		// revokes access and refresh tokens alike, and unknown tokens are
+		// not an error.
+		err := scoped(ctx, svc).ManageToken(service.NewDeleteTokenState(), req.Token)
+		if err != nil {
+			errMessage = err.Error()
+		}
+
+		return entity.ErrorResponse{Err: errMessage}, err
+	}
+}
+
+// MakeJWKSEndpoint ...
+func MakeJWKSEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(_ context.Context, _ any) (any, error) {
+		keys := []map[string]string{}
+
+		for _, key := range svc.PublicKeys() {
+			keys = append(keys, key.JWK())
+		}
+
+		return entity.JWKSResponse{Keys: keys}, nil
+	}
+}
+
+// MakeRotateKeysEndpoint ...
+func MakeRotateKeysEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(_ context.Context, _ any) (any, error) {
+		var errMessage string
+
+		key, err := svc.RotateKeys()
+		if err != nil {
+			errMessage = err.Error()
+		}
+
+		return entity.KeyIDErrResponse{KeyID: key.KeyID, Err: errMessage}, err
+	}
+}
+
+// MakeSessionsEndpoint ...
+func MakeSessionsEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req, ok := request.(entity.UserIDRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: isn't of type UserIDRequest", ErrRequest)
+		}
+
+		sessions, err := scoped(ctx, svc).Sessions(req.ID)
+		if err != nil {
+			return entity.SessionsErrResponse{Sessions: []entity.Session{}, Err: err.Error()}, err
+		}
+
+		return entity.SessionsErrResponse{Sessions: sessionsResponse(sessions)}, nil
+	}
+}
+
+// MakeRevokeSessionsEndpoint ...
+func MakeRevokeSessionsEndpoint(svc service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		var errMessage string
+
+		req, ok := request.(entity.UserIDRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: isn't of type UserIDRequest", ErrRequest)
+		}
+
+		revoked, err := scoped(ctx, svc).RevokeSessions(req.ID)
+		if err != nil {
+			errMessage = err.Error()
+		}
+
+		return entity.RevokedErrResponse{Revoked: revoked, Err: errMessage}, err
+	}
+}
+
+func sessionsResponse(sessions []service.Session) (response []entity.Session) {
+	response = make([]entity.Session, 0, len(sessions))
+
+	for _, session := range sessions {
+		response = append(response, entity.Session{
+			ID:        session.ID,
+			Type:      session.Type,
+			UserAgent: session.UserAgent,
+			IP:        session.IP,
+			IssuedAt:  session.IssuedAt,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+
+	return response
+}
+
+// scoped scopes svc to the tenant and client of the request, if any.
+func scoped(ctx context.Context, svc service.Service) service.Service {
+	if tenant := service.TenantFromContext(ctx); tenant != "" {
+		svc = svc.ForTenant(tenant)
+	}
+
+	if client := service.ClientFromContext(ctx); client != (service.Client{}) {
+		svc = svc.ForClient(client)
+	}
+
+	return svc
+}
@@ -2,14 +2,18 @@ package endpoint_test
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"testing"
+	"time"
 
 	"cache/internal/endpoint"
 	"cache/internal/entity"
 	"cache/internal/entity/mock"
 	"cache/internal/service"
+	"cache/internal/store"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
@@ -59,14 +63,14 @@ func TestMakeGenerateTokenEndpoint(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			svc := service.GetService(store.NewRedisStore(client), service.WithClientSecrets())
 
 			r, err := endpoint.MakeGenerateTokenEndpoint(svc)(context.TODO(), tt.in)
 			if err != nil {
 				resultErr = err.Error()
 			}
 
-			result, ok := r.(entity.Token)
+			result, ok := r.(entity.TokenIssuedAtExpiresAtErrResponse)
 			if !ok {
 				if tt.name != mock.NameErrorRequest {
 					assert.Fail(t, "response is not of the type indicated")
@@ -76,6 +80,7 @@ func TestMakeGenerateTokenEndpoint(t *testing.T) {
 			if tt.name == mock.NameNoError {
 				assert.Empty(t, resultErr)
 				assert.NotEmpty(t, result.Token)
+				assert.Greater(t, result.ExpiresAt, result.IssuedAt)
 			} else {
 				assert.Contains(t, resultErr, tt.outErr)
 				assert.Empty(t, result.Token)
@@ -143,7 +148,7 @@ func TestMakeExtractTokenEndpoint(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			svc := service.GetService(store.NewRedisStore(client), service.WithClientSecrets())
 
 			req, err = endpoint.MakeExtractTokenEndpoint(svc)(context.TODO(), tt.in)
 			if err != nil {
@@ -210,7 +215,7 @@ func TestMakeManageTokenEndpoint(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			svc := service.GetService(store.NewRedisStore(client))
 
 			if tt.name == mock.NameErrorRedisClose {
 				svc.DB.Close()
@@ -252,6 +257,21 @@ func TestMakeCheckTokenEndpoint(t *testing.T) {
 			in:     entity.Token{Token: "token"},
 			outErr: "",
 		},
+		{
+			name:   "Tenant",
+			in:     entity.Token{Token: "token"},
+			outErr: "",
+		},
+		{
+			name:   "InternalKey",
+			in:     entity.Token{Token: "internal:user_sessions:1"},
+			outErr: "",
+		},
+		{
+			name:   "OtherRecord",
+			in:     entity.Token{Token: "token"},
+			outErr: "",
+		},
 		{
 			name: mock.NameErrorRequest,
 			in: incorrectRequest{
@@ -278,13 +298,28 @@ func TestMakeCheckTokenEndpoint(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			svc := service.GetService(store.NewRedisStore(client))
 
 			if tt.name == mock.NameErrorRedisClose {
 				svc.DB.Close()
 			}
 
-			r, err := endpoint.MakeCheckTokenEndpoint(svc)(context.TODO(), tt.in)
+			ctx := context.TODO()
+
+			if tt.name == "Tenant" {
+				ctx = service.ContextWithTenant(ctx, "acme")
+				mr.Set("tenant:acme:token", "1")
+			}
+
+			// Only whitelisted tokens check, not the other records of the store.
+			switch tt.name {
+			case "InternalKey":
+				mr.Set("internal:user_sessions:1", "[]")
+			case "OtherRecord":
+				mr.Set("token", "active:family")
+			}
+
+			r, err := endpoint.MakeCheckTokenEndpoint(svc)(ctx, tt.in)
 			if err != nil {
 				resultErr = err.Error()
 			}
@@ -298,11 +333,735 @@ func TestMakeCheckTokenEndpoint(t *testing.T) {
 				resultErr = result.Err
 			}
 
+			switch tt.name {
+			case mock.NameNoError, "InternalKey", "OtherRecord":
+				assert.Empty(t, result.Err)
+				assert.False(t, result.Check)
+			case "Tenant":
+				assert.Empty(t, result.Err)
+				assert.True(t, result.Check)
+			default:
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+		})
+	}
+}
+
+func TestMakeJWKSEndpoint(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	signingKey, err := service.NewSigningKey(edKey)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		inKeys  []service.SigningKey
+		outKids []string
+	}{
+		{
+			name:    mock.NameNoError,
+			inKeys:  []service.SigningKey{signingKey},
+			outKids: []string{signingKey.KeyID},
+		},
+		{
+			name:    mock.NameNoError + "WithoutKeys",
+			inKeys:  nil,
+			outKids: []string{},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := service.GetService(nil, service.WithSigningKeys(tt.inKeys...))
+
+			r, err := endpoint.MakeJWKSEndpoint(svc)(context.TODO(), nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			result, ok := r.(entity.JWKSResponse)
+			if !ok {
+				assert.Fail(t, "response is not of the type indicated")
+			}
+
+			kids := []string{}
+			for _, key := range result.Keys {
+				kids = append(kids, key["kid"])
+			}
+
+			assert.Equal(t, tt.outKids, kids)
+		})
+	}
+}
+
+func TestMakeRotateKeysEndpoint(t *testing.T) {
+	t.Parallel()
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodES256.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		inKeyring *service.Keyring
+		outErr    string
+	}{
+		{
+			name:      mock.NameNoError,
+			inKeyring: service.NewKeyring(time.Minute, key),
+			outErr:    "",
+		},
+		{
+			name:      "ErrorNoKeyring",
+			inKeyring: nil,
+			outErr:    service.ErrNoKeyring.Error(),
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := service.GetService(nil, service.WithKeyring(tt.inKeyring))
+
+			r, err := endpoint.MakeRotateKeysEndpoint(svc)(context.TODO(), nil)
+			if tt.name == mock.NameNoError {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, service.ErrNoKeyring)
+			}
+
+			result, ok := r.(entity.KeyIDErrResponse)
+			if !ok {
+				assert.Fail(t, "response is not of the type indicated")
+			}
+
 			if tt.name == mock.NameNoError {
 				assert.Empty(t, result.Err)
+				assert.Equal(t, tt.inKeyring.Active().KeyID, result.KeyID)
+				assert.NotEqual(t, key.KeyID, result.KeyID)
+			} else {
+				assert.Contains(t, result.Err, tt.outErr)
+			}
+		})
+	}
+}
+
+func TestMakeRefreshTokenEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		in     any
+		name   string
+		outErr string
+	}{
+		{
+			name:   mock.NameNoError,
+			in:     nil,
+			outErr: "",
+		},
+		{
+			name: mock.NameErrorRequest,
+			in: incorrectRequest{
+				incorrect: true,
+			},
+			outErr: "isn't of type",
+		},
+		{
+			name:   "ErrorNotValidToken",
+			in:     entity.RefreshTokenRequest{RefreshToken: ""},
+			outErr: "token contains an invalid number of segments",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodES256.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client), service.WithSigningKeys(key))
+
+			in := tt.in
+			if in == nil {
+				r, err := endpoint.MakeGenerateTokenEndpoint(svc)(context.TODO(), entity.IDUsernameEmailSecretRequest{
+					ID:       mock.IDTest,
+					Username: mock.UsernameTest,
+					Email:    mock.EmailTest,
+				})
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				generated, _ := r.(entity.TokenIssuedAtExpiresAtErrResponse)
+				assert.NotEmpty(t, generated.RefreshToken)
+
+				in = entity.RefreshTokenRequest{RefreshToken: generated.RefreshToken}
+			}
+
+			r, err := endpoint.MakeRefreshTokenEndpoint(svc)(context.TODO(), in)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			result, ok := r.(entity.TokenIssuedAtExpiresAtErrResponse)
+			if !ok {
+				if tt.name != mock.NameErrorRequest {
+					assert.Fail(t, "response is not of the type indicated")
+				}
+			} else {
+				resultErr = result.Err
+			}
+
+			if tt.name == mock.NameNoError {
+				assert.Empty(t, resultErr)
+				assert.NotEmpty(t, result.Token)
+				assert.NotEmpty(t, result.RefreshToken)
 			} else {
 				assert.Contains(t, resultErr, tt.outErr)
 			}
 		})
 	}
 }
+
+func TestMakeIntrospectTokenEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		in           any
+		name         string
+		outTokenType string
+		outErr       string
+		outActive    bool
+	}{
+		{
+			name:         mock.NameNoError,
+			in:           nil,
+			outTokenType: "Bearer",
+			outActive:    true,
+			outErr:       "",
+		},
+		{
+			name: mock.NameErrorRequest,
+			in: incorrectRequest{
+				incorrect: true,
+			},
+			outErr: "isn't of type",
+		},
+		{
+			name:      mock.NameNoError + "Inactive",
+			in:        entity.TokenTypeHintRequest{Token: mock.TokenTest},
+			outActive: false,
+			outErr:    "",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodES256.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client), service.WithSigningKeys(key))
+
+			in := tt.in
+			if in == nil {
+				token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				if err = svc.ManageToken(service.NewSetTokenState(), token); err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				in = entity.TokenTypeHintRequest{Token: token, TokenTypeHint: "access_token"}
+			}
+
+			r, err := endpoint.MakeIntrospectTokenEndpoint(svc)(context.TODO(), in)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			result, ok := r.(entity.IntrospectionResponse)
+			if !ok {
+				if tt.name != mock.NameErrorRequest {
+					assert.Fail(t, "response is not of the type indicated")
+				}
+			} else {
+				resultErr = result.Err
+			}
+
+			if tt.outErr == "" {
+				assert.Empty(t, resultErr)
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+
+			assert.Equal(t, tt.outActive, result.Active)
+			assert.Equal(t, tt.outTokenType, result.TokenType)
+
+			if tt.outActive {
+				assert.Equal(t, "1", result.Subject)
+				assert.Equal(t, mock.UsernameTest, result.Username)
+			}
+		})
+	}
+}
+
+func TestMakeRevokeTokenEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		in     any
+		name   string
+		outErr string
+	}{
+		{
+			name:   mock.NameNoError,
+			in:     entity.TokenTypeHintRequest{Token: mock.TokenTest, TokenTypeHint: "access_token"},
+			outErr: "",
+		},
+		{
+			name:   mock.NameNoError + "UnknownToken",
+			in:     entity.TokenTypeHintRequest{Token: "unknown", TokenTypeHint: "refresh_token"},
+			outErr: "",
+		},
+		{
+			name: mock.NameErrorRequest,
+			in: incorrectRequest{
+				incorrect: true,
+			},
+			outErr: "isn't of type",
+		},
+		{
+			name:   mock.NameErrorRedisClose,
+			in:     entity.TokenTypeHintRequest{Token: mock.TokenTest},
+			outErr: mock.ErrRedisClosed,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			svc := service.GetService(store.NewRedisStore(client))
+
+			if err = svc.ManageToken(service.NewSetTokenState(), mock.TokenTest); err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			if tt.name == mock.NameErrorRedisClose {
+				svc.DB.Close()
+			}
+
+			r, err := endpoint.MakeRevokeTokenEndpoint(svc)(context.TODO(), tt.in)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			result, ok := r.(entity.ErrorResponse)
+			if !ok {
+				if tt.name != mock.NameErrorRequest {
+					assert.Fail(t, "response is not of the type indicated")
+				}
+			} else {
+				resultErr = result.Err
+			}
+
+			if tt.outErr == "" {
+				req, _ := tt.in.(entity.TokenTypeHintRequest)
+
+				assert.Empty(t, resultErr)
+				assert.False(t, mr.Exists(req.Token))
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+		})
+	}
+}
+
+func TestMakeSessionsEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		in          any
+		name        string
+		outErr      string
+		outSessions int
+	}{
+		{
+			name:        mock.NameNoError,
+			in:          entity.UserIDRequest{ID: mock.IDTest},
+			outErr:      "",
+			outSessions: 1,
+		},
+		{
+			name:        mock.NameNoError + "UnknownUser",
+			in:          entity.UserIDRequest{ID: mock.IDTest + 1},
+			outErr:      "",
+			outSessions: 0,
+		},
+		{
+			name: mock.NameErrorRequest,
+			in: incorrectRequest{
+				incorrect: true,
+			},
+			outErr: "isn't of type",
+		},
+		{
+			name:   mock.NameErrorRedisClose,
+			in:     entity.UserIDRequest{ID: mock.IDTest},
+			outErr: mock.ErrRedisClosed,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			svc, db := sessionService(t)
+
+			if tt.name == mock.NameErrorRedisClose {
+				db.Close()
+			}
+
+			ctx := service.ContextWithClient(context.TODO(), service.Client{UserAgent: "curl/8.0"})
+
+			r, err := endpoint.MakeSessionsEndpoint(svc)(ctx, tt.in)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			result, ok := r.(entity.SessionsErrResponse)
+			if !ok {
+				if tt.name != mock.NameErrorRequest {
+					assert.Fail(t, "response is not of the type indicated")
+				}
+			} else {
+				resultErr = result.Err
+			}
+
+			if tt.outErr == "" {
+				assert.Empty(t, resultErr)
+				assert.Len(t, result.Sessions, tt.outSessions)
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+		})
+	}
+}
+
+func TestMakeRevokeSessionsEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		in         any
+		name       string
+		outErr     string
+		outRevoked int
+	}{
+		{
+			name:       mock.NameNoError,
+			in:         entity.UserIDRequest{ID: mock.IDTest},
+			outErr:     "",
+			outRevoked: 1,
+		},
+		{
+			name: mock.NameErrorRequest,
+			in: incorrectRequest{
+				incorrect: true,
+			},
+			outErr: "isn't of type",
+		},
+		{
+			name:   mock.NameErrorRedisClose,
+			in:     entity.UserIDRequest{ID: mock.IDTest},
+			outErr: mock.ErrRedisClosed,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			svc, db := sessionService(t)
+
+			if tt.name == mock.NameErrorRedisClose {
+				db.Close()
+			}
+
+			r, err := endpoint.MakeRevokeSessionsEndpoint(svc)(context.TODO(), tt.in)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			result, ok := r.(entity.RevokedErrResponse)
+			if !ok {
+				if tt.name != mock.NameErrorRequest {
+					assert.Fail(t, "response is not of the type indicated")
+				}
+			} else {
+				resultErr = result.Err
+			}
+
+			if tt.outErr == "" {
+				assert.Empty(t, resultErr)
+				assert.Equal(t, tt.outRevoked, result.Revoked)
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+		})
+	}
+}
+
+// sessionService returns a service holding one token of mock.IDTest, and
+// its store.
+func sessionService(t *testing.T) (svc service.Service, db store.TokenStore) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	db = store.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	svc = service.GetService(db, service.WithSigningKeys(key))
+
+	token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	if err = svc.ManageToken(service.NewSetTokenState(), token); err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	return svc, db
+}
+
+func TestMakeStoreTokenEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		in         any
+		name       string
+		outErr     string
+		inTTL      int64
+		outEvicted int
+	}{
+		{
+			name:       mock.NameNoError,
+			outErr:     "",
+			outEvicted: 1,
+		},
+		{
+			name:       mock.NameNoError + "TTL",
+			inTTL:      60,
+			outErr:     "",
+			outEvicted: 1,
+		},
+		{
+			name: mock.NameErrorRequest,
+			in: incorrectRequest{
+				incorrect: true,
+			},
+			outErr: "isn't of type",
+		},
+		{
+			name:   mock.NameErrorRedisClose,
+			outErr: mock.ErrRedisClosed,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			db := store.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(db,
+				service.WithSigningKeys(key), service.WithMaxSessions(1, service.SessionLimitEvictOldest))
+
+			var tokens [2]string
+
+			for i := range tokens {
+				tokens[i], _, _, err = svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+			}
+
+			if _, err = svc.StoreToken(tokens[0], 0); err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			if tt.name == mock.NameErrorRedisClose {
+				db.Close()
+			}
+
+			in := tt.in
+			if in == nil {
+				in = entity.Token{Token: tokens[1], TTL: tt.inTTL}
+			}
+
+			r, err := endpoint.MakeStoreTokenEndpoint(svc)(context.TODO(), in)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			result, ok := r.(entity.EvictedErrResponse)
+			if !ok {
+				if tt.name != mock.NameErrorRequest {
+					assert.Fail(t, "response is not of the type indicated")
+				}
+			} else {
+				resultErr = result.Err
+			}
+
+			if tt.outErr == "" {
+				assert.Empty(t, resultErr)
+				assert.Len(t, result.Evicted, tt.outEvicted)
+				assert.False(t, mr.Exists(tokens[0]))
+				assert.True(t, mr.Exists(tokens[1]))
+
+				if tt.inTTL != 0 {
+					assert.Equal(t, time.Duration(tt.inTTL)*time.Second, mr.TTL(tokens[1]))
+				}
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+		})
+	}
+}
+
+func TestMakeAuthEndpoint(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		in     any
+		name   string
+		outErr error
+		store  bool
+	}{
+		{
+			name:  mock.NameNoError,
+			store: true,
+		},
+		{
+			name:   "ErrorInactive",
+			outErr: endpoint.ErrInactiveToken,
+		},
+		{
+			name:   "ErrorNotValidToken",
+			in:     entity.Token{Token: "a.b.c"},
+			outErr: nil,
+		},
+		{
+			name:   mock.NameErrorRequest,
+			in:     incorrectRequest{incorrect: true},
+			outErr: endpoint.ErrRequest,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc, _ := sessionService(t)
+
+			token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			if tt.store {
+				if err = svc.ManageToken(service.NewSetTokenState(), token); err != nil {
+					assert.Fail(t, err.Error())
+				}
+			}
+
+			in := tt.in
+			if in == nil {
+				in = entity.Token{Token: token}
+			}
+
+			r, err := endpoint.MakeAuthEndpoint(svc)(context.TODO(), in)
+
+			switch {
+			case tt.name == mock.NameNoError:
+				assert.NoError(t, err)
+
+				result, ok := r.(entity.IDUsernameEmailErrResponse)
+				if !ok {
+					assert.Fail(t, "response is not of the type indicated")
+				}
+
+				assert.Equal(t, mock.IDTest, result.ID)
+				assert.Equal(t, mock.UsernameTest, result.Username)
+				assert.Equal(t, mock.EmailTest, result.Email)
+				assert.Empty(t, result.Err)
+			case tt.outErr != nil:
+				assert.ErrorIs(t, err, tt.outErr)
+			default:
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -17,14 +17,42 @@ type TokenSecretRequest struct {
 // Token ...
 type Token struct {
 	Token string `json:"token"`
+	TTL   int64  `json:"ttl,omitempty"`
+}
+
+// TokenTypeHintRequest ...
+type TokenTypeHintRequest struct {
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"token_type_hint"`
+}
+
+// RefreshTokenRequest ...
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// UserIDRequest ...
+type UserIDRequest struct {
+	ID int `json:"id"`
+}
+
+// TokenIssuedAtExpiresAtErrResponse ...
+type TokenIssuedAtExpiresAtErrResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Err          string `json:"err,omitempty"`
+	IssuedAt     int64  `json:"iat"`
+	ExpiresAt    int64  `json:"exp"`
 }
 
 // IDUsernameEmailErrResponse ...
 type IDUsernameEmailErrResponse struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Err      string `json:"err,omitempty"`
-	ID       int    `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Err       string `json:"err,omitempty"`
+	ID        int    `json:"id"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
 }
 
 // ErrorResponse ...
@@ -32,8 +60,83 @@ type ErrorResponse struct {
 	Err string `json:"err,omitempty"`
 }
 
+// ErrorEnvelope is the body of every failed HTTP response. Code is stable
+// for clients to switch on; Message is meant for humans.
+type ErrorEnvelope struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
 // CheckErrResponse ...
 type CheckErrResponse struct {
 	Err   string `json:"err,omitempty"`
 	Check bool   `json:"check"`
 }
+
+// JWKSResponse ...
+type JWKSResponse struct {
+	Keys []map[string]string `json:"keys"`
+}
+
+// KeyIDErrResponse ...
+type KeyIDErrResponse struct {
+	KeyID string `json:"kid"`
+	Err   string `json:"err,omitempty"`
+}
+
+// IntrospectionResponse ...
+type IntrospectionResponse struct {
+	Subject   string `json:"sub,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	TokenID   string `json:"jti,omitempty"`
+	Err       string `json:"err,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Active    bool   `json:"active"`
+}
+
+// Session ...
+type Session struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	UserAgent string `json:"user_agent,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// SessionsErrResponse ...
+type SessionsErrResponse struct {
+	Err      string    `json:"err,omitempty"`
+	Sessions []Session `json:"sessions"`
+}
+
+// RevokedErrResponse ...
+type RevokedErrResponse struct {
+	Err     string `json:"err,omitempty"`
+	Revoked int    `json:"revoked"`
+}
+
+// EvictedErrResponse ...
+type EvictedErrResponse struct {
+	Err     string    `json:"err,omitempty"`
+	Evicted []Session `json:"evicted,omitempty"`
+}
+
+// Codes of the ErrorEnvelope.
+const (
+	CodeInvalidRequest      = "invalid_request"
+	CodeInvalidToken        = "invalid_token"
+	CodeTokenExpired        = "token_expired"
+	CodeInvalidRefreshToken = "invalid_refresh_token"
+	CodeForbidden           = "forbidden"
+	CodeTooManySessions     = "too_many_sessions"
+	CodeConflict            = "conflict"
+	CodeUnavailable         = "unavailable"
+	CodeInternal            = "internal"
+)
@@ -0,0 +1,257 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: internal/pb/token.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Token_Generate_FullMethodName    = "/token.Token/Generate"
+	Token_Extract_FullMethodName     = "/token.Token/Extract"
+	Token_SetToken_FullMethodName    = "/token.Token/SetToken"
+	Token_DeleteToken_FullMethodName = "/token.Token/DeleteToken"
+	Token_CheckToken_FullMethodName  = "/token.Token/CheckToken"
+)
+
+// TokenClient is the client API for Token service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TokenClient interface {
+	Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (*GenerateReply, error)
+	Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractReply, error)
+	SetToken(ctx context.Context, in *SetTokenRequest, opts ...grpc.CallOption) (*SetTokenReply, error)
+	DeleteToken(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*DeleteTokenReply, error)
+	CheckToken(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*CheckTokenReply, error)
+}
+
+type tokenClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTokenClient(cc grpc.ClientConnInterface) TokenClient {
+	return &tokenClient{cc}
+}
+
+func (c *tokenClient) Generate(ctx context.Context, in *GenerateRequest, opts ...grpc.CallOption) (*GenerateReply, error) {
+	out := new(GenerateReply)
+	err := c.cc.Invoke(ctx, Token_Generate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) Extract(ctx context.Context, in *ExtractRequest, opts ...grpc.CallOption) (*ExtractReply, error) {
+	out := new(ExtractReply)
+	err := c.cc.Invoke(ctx, Token_Extract_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) SetToken(ctx context.Context, in *SetTokenRequest, opts ...grpc.CallOption) (*SetTokenReply, error) {
+	out := new(SetTokenReply)
+	err := c.cc.Invoke(ctx, Token_SetToken_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) DeleteToken(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*DeleteTokenReply, error) {
+	out := new(DeleteTokenReply)
+	err := c.cc.Invoke(ctx, Token_DeleteToken_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tokenClient) CheckToken(ctx context.Context, in *TokenRequest, opts ...grpc.CallOption) (*CheckTokenReply, error) {
+	out := new(CheckTokenReply)
+	err := c.cc.Invoke(ctx, Token_CheckToken_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TokenServer is the server API for Token service.
+// All implementations must embed UnimplementedTokenServer
+// for forward compatibility
+type TokenServer interface {
+	Generate(context.Context, *GenerateRequest) (*GenerateReply, error)
+	Extract(context.Context, *ExtractRequest) (*ExtractReply, error)
+	SetToken(context.Context, *SetTokenRequest) (*SetTokenReply, error)
+	DeleteToken(context.Context, *TokenRequest) (*DeleteTokenReply, error)
+	CheckToken(context.Context, *TokenRequest) (*CheckTokenReply, error)
+	mustEmbedUnimplementedTokenServer()
+}
+
+// UnimplementedTokenServer must be embedded to have forward compatible implementations.
+type UnimplementedTokenServer struct {
+}
+
+func (UnimplementedTokenServer) Generate(context.Context, *GenerateRequest) (*GenerateReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Generate not implemented")
+}
+func (UnimplementedTokenServer) Extract(context.Context, *ExtractRequest) (*ExtractReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Extract not implemented")
+}
+func (UnimplementedTokenServer) SetToken(context.Context, *SetTokenRequest) (*SetTokenReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetToken not implemented")
+}
+func (UnimplementedTokenServer) DeleteToken(context.Context, *TokenRequest) (*DeleteTokenReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteToken not implemented")
+}
+func (UnimplementedTokenServer) CheckToken(context.Context, *TokenRequest) (*CheckTokenReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckToken not implemented")
+}
+func (UnimplementedTokenServer) mustEmbedUnimplementedTokenServer() {}
+
+// UnsafeTokenServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TokenServer will
+// result in compilation errors.
+type UnsafeTokenServer interface {
+	mustEmbedUnimplementedTokenServer()
+}
+
+func RegisterTokenServer(s grpc.ServiceRegistrar, srv TokenServer) {
+	s.RegisterService(&Token_ServiceDesc, srv)
+}
+
+func _Token_Generate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GenerateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).Generate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Token_Generate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).Generate(ctx, req.(*GenerateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_Extract_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtractRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).Extract(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Token_Extract_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).Extract(ctx, req.(*ExtractRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_SetToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).SetToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Token_SetToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).SetToken(ctx, req.(*SetTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_DeleteToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).DeleteToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Token_DeleteToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).DeleteToken(ctx, req.(*TokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Token_CheckToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TokenServer).CheckToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Token_CheckToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TokenServer).CheckToken(ctx, req.(*TokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Token_ServiceDesc is the grpc.ServiceDesc for Token service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Token_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "token.Token",
+	HandlerType: (*TokenServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Generate",
+			Handler:    _Token_Generate_Handler,
+		},
+		{
+			MethodName: "Extract",
+			Handler:    _Token_Extract_Handler,
+		},
+		{
+			MethodName: "SetToken",
+			Handler:    _Token_SetToken_Handler,
+		},
+		{
+			MethodName: "DeleteToken",
+			Handler:    _Token_DeleteToken_Handler,
+		},
+		{
+			MethodName: "CheckToken",
+			Handler:    _Token_CheckToken_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "internal/pb/token.proto",
+}
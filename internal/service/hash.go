@@ -0,0 +1,228 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cache/internal/store"
+)
+
+const (
+	base64URLAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+	// internalKeyPrefix starts the key of every record the service stores
+	// besides whitelisted tokens. Tokens starting with it are refused, so
+	// tokenKey never returns such a key.
+	internalKeyPrefix = "internal:"
+)
+
+var (
+	ErrNoPepper    = errors.New("no token pepper configured")
+	ErrReservedKey = errors.New("token collides with a reserved key")
+)
+
+// WithTokenPepper stores tokens under their HMAC-SHA256 keyed with pepper
+// rather than under the raw token, so the store never holds a usable bearer
+// token. Every replica sharing a store must use the same pepper.
+func WithTokenPepper(pepper []byte) Option {
+	return func(s *service) {
+		s.pepper = pepper
+	}
+}
+
+// WithRawKeyMigration makes lookups that miss the hashed key fall back to the
+// raw token key written without WithTokenPepper, moving what they find. Enable
+// it with WithTokenPepper until MigrateRawKeys has run and no replica writes
+// raw keys anymore.
+func WithRawKeyMigration() Option {
+	return func(s *service) {
+		s.rawKeyMigration = true
+	}
+}
+
+// MigrateRawKeys moves every entry stored under a raw token key to its hashed
+// key and returns how many it moved. A service without a tenant migrates its
+// own namespace and the namespace of every tenant in it. Keys that are not
+// JWTs are left alone. It is safe to run while serving and more than once.
+// Stores that cannot list their keys return ErrNoKeys; their raw keys are only
+// migrated as they are looked up, with WithRawKeyMigration.
+func (s *service) MigrateRawKeys() (migrated int, err error) {
+	if len(s.pepper) == 0 {
+		return 0, ErrNoPepper
+	}
+
+	keys, err := s.namespace(s.tenant).Keys("")
+	if errors.Is(err, store.ErrNoKeys) {
+		return 0, fmt.Errorf("%w: migrate raw keys on lookup with WithRawKeyMigration", err)
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error to list keys: %w", err)
+	}
+
+	var moved bool
+
+	for _, key := range keys {
+		tenant, rawKey := s.tenant, key
+		if tenant == "" {
+			tenant, rawKey = splitTenant(key)
+		}
+
+		if !isRawKey(rawKey) {
+			continue
+		}
+
+		if moved, err = s.migrate(s.namespace(tenant), rawKey); err != nil {
+			return migrated, err
+		}
+
+		if moved {
+			migrated++
+		}
+	}
+
+	return migrated, nil
+}
+
+// tokenKey returns the key token is stored under.
+func (s service) tokenKey(token string) (key string) {
+	if len(s.pepper) == 0 {
+		return token
+	}
+
+	mac := hmac.New(sha256.New, s.pepper)
+	mac.Write([]byte(token))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// migrating reports whether lookups fall back to raw token keys.
+func (s service) migrating() (migrating bool) {
+	return s.rawKeyMigration && len(s.pepper) != 0
+}
+
+// migrate moves the raw keys in db, with their TTL, to the hashed keys and
+// reports whether any was found.
+func (s service) migrate(db store.TokenStore, rawKeys ...string) (moved bool, err error) {
+	err = db.Update(func(tx store.Tx) error {
+		moved = false
+
+		for _, key := range rawKeys {
+			found, moveErr := s.moveRawKey(tx, key)
+			if moveErr != nil {
+				return moveErr
+			}
+
+			moved = moved || found
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error to migrate raw token key: %w", err)
+	}
+
+	return moved, nil
+}
+
+func (s service) moveRawKey(tx store.Tx, key string) (found bool, err error) {
+	value, err := tx.Get(key)
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, store.ErrWrongType) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	ttl, err := tx.TTL(key)
+	if err != nil {
+		return false, err
+	}
+
+	if strings.HasPrefix(key, refreshTokenPrefix) {
+		_, family, _ := strings.Cut(value, ":")
+
+		if err = s.migrateFamily(tx, refreshFamilyPrefix+family); err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Set(s.hashKey(key), value, ttl); err != nil {
+		return false, err
+	}
+
+	if session, sessionErr := s.session(strings.TrimPrefix(key, refreshTokenPrefix), ""); sessionErr == nil {
+		if err = store.SetSubject(tx, s.hashKey(key), strconv.Itoa(session.UserID)); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Delete(key)
+}
+
+// migrateFamily rewrites the raw keys listed in the family as hashed keys.
+func (s service) migrateFamily(tx store.Tx, familyKey string) (err error) {
+	members, err := familyMembers(tx, familyKey)
+	if err != nil || len(members) == 0 {
+		return err
+	}
+
+	ttl, err := tx.TTL(familyKey)
+	if err != nil {
+		return err
+	}
+
+	for i, member := range members {
+		members[i] = s.hashKey(member)
+	}
+
+	return tx.Set(familyKey, strings.Join(members, "\n"), ttl)
+}
+
+// hashKey maps a raw key, a token with or without the refresh prefix, to the
+// key it is stored under now. Other keys are returned unchanged.
+func (s service) hashKey(key string) (hashed string) {
+	if !isRawKey(key) {
+		return key
+	}
+
+	token := strings.TrimPrefix(key, refreshTokenPrefix)
+
+	return key[:len(key)-len(token)] + s.tokenKey(token)
+}
+
+// isRawKey tells raw token keys from hashed ones and from keys that are not
+// ours: only a JWT, three base64url segments the first of which is a JOSE
+// header, is a raw token. Without a key prefix the store is shared with other
+// applications, so anything looser would migrate their keys.
+func isRawKey(key string) (raw bool) {
+	segments := strings.Split(strings.TrimPrefix(key, refreshTokenPrefix), ".")
+	if len(segments) != 3 {
+		return false
+	}
+
+	for _, segment := range segments {
+		if segment == "" || strings.Trim(segment, base64URLAlphabet) != "" {
+			return false
+		}
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(segments[0])
+	if err != nil {
+		return false
+	}
+
+	var jose struct {
+		Alg string `json:"alg"`
+	}
+
+	return json.Unmarshal(header, &jose) == nil && jose.Alg != ""
+}
@@ -0,0 +1,32 @@
+package service
+
+import "errors"
+
+// IntrospectToken reports whether token is active: its signature and time
+// claims are valid for the server-held keys and it is still stored, as a
+// whitelisted access token or an unused refresh token. An invalid token is
+// only inactive; err is reserved for storage failures.
+func (s *service) IntrospectToken(token string) (claims Claims, active bool, err error) {
+	claims, err = s.parseToken(token, nil)
+	if err != nil {
+		return Claims{}, false, nil
+	}
+
+	switch claims.Type {
+	case "":
+		active, err = s.CheckToken(token)
+	case refreshTokenType:
+		active, err = s.checkRefreshToken(token)
+	}
+
+	// A token of another tenant is not active here.
+	if errors.Is(err, ErrTenantMismatch) || errors.Is(err, ErrTenant) {
+		return Claims{}, false, nil
+	}
+
+	if err != nil || !active {
+		return Claims{}, false, err
+	}
+
+	return claims, true, nil
+}
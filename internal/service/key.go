@@ -0,0 +1,233 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// SigningKey ...
+type SigningKey struct {
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+	KeyID   string
+}
+
+const (
+	rsaKeyBits   int = 2048
+	hmacKeyBytes int = 32
+)
+
+var (
+	ErrKeyFormat      = errors.New("error to decode key")
+	ErrKeyUnsupported = errors.New("unsupported key type")
+)
+
+// LoadSigningKey reads a PEM encoded RSA, ECDSA or Ed25519 private key from path.
+func LoadSigningKey(path string) (key SigningKey, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("error to read key file: %w", err)
+	}
+
+	return ParseSigningKey(data)
+}
+
+// ParseSigningKey decodes a PEM encoded PKCS#8, PKCS#1 or SEC 1 private key.
+func ParseSigningKey(data []byte) (key SigningKey, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return SigningKey{}, fmt.Errorf("%w: no PEM block found", ErrKeyFormat)
+	}
+
+	private, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	return NewSigningKey(private)
+}
+
+// GenerateSigningKey creates a fresh random key for the algorithm alg.
+func GenerateSigningKey(alg string) (key SigningKey, err error) {
+	var private crypto.PrivateKey
+
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		secret := make([]byte, hmacKeyBytes)
+		_, err = rand.Read(secret)
+		private = secret
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodES384.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jwt.SigningMethodES512.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return SigningKey{}, fmt.Errorf("%w: algorithm %s", ErrKeyUnsupported, alg)
+	}
+
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("error to generate key: %w", err)
+	}
+
+	return NewSigningKey(private)
+}
+
+// NewSigningKey chooses the signing method matching the private key type and
+// derives the key ID from the RFC 7638 thumbprint of the key. A []byte is
+// taken as an HS256 secret and has no public half.
+func NewSigningKey(private crypto.PrivateKey) (key SigningKey, err error) {
+	switch k := private.(type) {
+	case []byte:
+		key = SigningKey{Method: jwt.SigningMethodHS256, Private: k}
+
+	case *rsa.PrivateKey:
+		key = SigningKey{Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}
+
+	case *ecdsa.PrivateKey:
+		var method jwt.SigningMethod
+
+		if method, err = ecdsaMethod(k.Curve); err != nil {
+			return SigningKey{}, err
+		}
+
+		key = SigningKey{Method: method, Private: k, Public: &k.PublicKey}
+
+	case ed25519.PrivateKey:
+		public, _ := k.Public().(ed25519.PublicKey)
+
+		key = SigningKey{Method: jwt.SigningMethodEdDSA, Private: k, Public: public}
+
+	default:
+		return SigningKey{}, fmt.Errorf("%w: %T", ErrKeyUnsupported, private)
+	}
+
+	params := publicParams(key.Public)
+	if secret, ok := private.([]byte); ok {
+		params = map[string]string{"kty": "oct", "k": encodeSegment(secret)}
+	}
+
+	thumbprint, _ := json.Marshal(params)
+	sum := sha256.Sum256(thumbprint)
+	key.KeyID = encodeSegment(sum[:])
+
+	return key, nil
+}
+
+// Generate creates a new random key of the same algorithm and size, for rotation.
+func (k SigningKey) Generate() (key SigningKey, err error) {
+	rsaKey, ok := k.Private.(*rsa.PrivateKey)
+	if !ok {
+		return GenerateSigningKey(k.Method.Alg())
+	}
+
+	private, err := rsa.GenerateKey(rand.Reader, rsaKey.N.BitLen())
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("error to generate key: %w", err)
+	}
+
+	return NewSigningKey(private)
+}
+
+// verificationKey is the public key, or the secret itself for HMAC keys.
+func (k SigningKey) verificationKey() (key any) {
+	if k.Public == nil {
+		return k.Private
+	}
+
+	return k.Public
+}
+
+// JWK returns the public half of the key as an RFC 7517 JSON Web Key.
+func (k SigningKey) JWK() (jwk map[string]string) {
+	jwk = publicParams(k.Public)
+	jwk["kid"] = k.KeyID
+	jwk["alg"] = k.Method.Alg()
+	jwk["use"] = "sig"
+
+	return jwk
+}
+
+// publicParams returns the members RFC 7638 requires to identify a public key.
+func publicParams(public crypto.PublicKey) (params map[string]string) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   encodeSegment(k.N.Bytes()),
+			"e":   encodeSegment(big.NewInt(int64(k.E)).Bytes()),
+		}
+
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+
+		return map[string]string{
+			"kty": "EC",
+			"crv": k.Curve.Params().Name,
+			"x":   encodeSegment(k.X.FillBytes(make([]byte, size))),
+			"y":   encodeSegment(k.Y.FillBytes(make([]byte, size))),
+		}
+
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   encodeSegment(k),
+		}
+	}
+
+	return map[string]string{}
+}
+
+func encodeSegment(data []byte) (segment string) {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parsePrivateKey(der []byte) (private crypto.PrivateKey, err error) {
+	if private, err = x509.ParsePKCS8PrivateKey(der); err == nil {
+		return private, nil
+	}
+
+	if private, err = x509.ParsePKCS1PrivateKey(der); err == nil {
+		return private, nil
+	}
+
+	if private, err = x509.ParseECPrivateKey(der); err == nil {
+		return private, nil
+	}
+
+	return nil, fmt.Errorf("%w: not a PKCS#8, PKCS#1 or SEC 1 private key", ErrKeyFormat)
+}
+
+func ecdsaMethod(curve elliptic.Curve) (method jwt.SigningMethod, err error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	}
+
+	return nil, fmt.Errorf("%w: curve %s", ErrKeyUnsupported, curve.Params().Name)
+}
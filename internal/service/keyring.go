@@ -0,0 +1,438 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"cache/internal/store"
+)
+
+// Keyring holds the active signing key together with the keys that may still
+// verify tokens. A rotated key is published, for verification and in the
+// JWKS, as soon as it is created but only signs once it becomes active; the
+// key it replaces is dropped once the grace period has elapsed.
+type Keyring struct {
+	createdAt    time.Time
+	loadedAt     time.Time
+	db           store.TokenStore
+	aead         cipher.AEAD
+	static       []SigningKey
+	rotated      []rotatedKey
+	grace        time.Duration
+	publishAhead time.Duration
+	version      uint64
+	syncing      bool
+	mu           sync.Mutex
+}
+
+// rotatedKey is a key created by Rotate and when it starts signing.
+type rotatedKey struct {
+	activeAt time.Time
+	key      SigningKey
+}
+
+// sharedKey is a rotatedKey as sealed in the store.
+type sharedKey struct {
+	PEM      string `json:"pem,omitempty"`
+	Secret   []byte `json:"secret,omitempty"`
+	ActiveAt int64  `json:"active_at"`
+}
+
+const (
+	keyringKey = internalKeyPrefix + "signing_keys"
+
+	// keyringSyncInterval bounds how stale the shared keys of a replica get.
+	keyringSyncInterval = 10 * time.Second
+)
+
+var (
+	ErrNoKeyringSecret = errors.New("no keyring secret configured")
+	ErrKeyringSecret   = errors.New("shared keys do not decrypt with the keyring secret")
+)
+
+// NewKeyring signs with active and verifies with active and keys. Keys given
+// here never expire; only active, once rotated, is subject to grace.
+func NewKeyring(grace time.Duration, active SigningKey, keys ...SigningKey) *Keyring {
+	return &Keyring{
+		createdAt: time.Now(),
+		static:    append([]SigningKey{active}, keys...),
+		grace:     grace,
+	}
+}
+
+// Share keeps the rotated keys in db, so that every replica using db signs
+// and verifies with the same keys, across restarts. The keys are encrypted
+// with AES-GCM under secret, which every replica must share. A rotated key
+// only signs publishAhead after Rotate, which should be at least how long
+// consumers cache the JWKS.
+func (k *Keyring) Share(db store.TokenStore, secret []byte, publishAhead time.Duration) (err error) {
+	if len(secret) == 0 {
+		return ErrNoKeyringSecret
+	}
+
+	// AES-256 needs a 32 byte key, whatever the length of secret.
+	sum := sha256.Sum256(secret)
+
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return fmt.Errorf("error to share keys: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("error to share keys: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.db = db
+	k.aead = aead
+	k.publishAhead = publishAhead
+
+	return k.load(time.Now())
+}
+
+// Active returns the key new tokens are signed with.
+func (k *Keyring) Active() SigningKey {
+	k.sync()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.active(time.Now())
+}
+
+// Keys returns the active key followed by every key still valid for
+// verification, including the rotated keys not active yet.
+func (k *Keyring) Keys() (keys []SigningKey) {
+	k.sync()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	active := k.active(now)
+	keys = append(keys, active)
+
+	chain := k.chain()
+
+	for i, entry := range chain {
+		if entry.key.KeyID != active.KeyID && k.valid(chain, i, now) {
+			keys = append(keys, entry.key)
+		}
+	}
+
+	return append(keys, k.static[1:]...)
+}
+
+// Rotate creates a fresh key of the same algorithm, which replaces the active
+// key once published for the publishAhead given to Share. The replaced key
+// keeps verifying tokens for the grace period.
+func (k *Keyring) Rotate() (key SigningKey, err error) {
+	key, _, err = k.rotate(0)
+
+	return key, err
+}
+
+// RotateEvery rotates the keys every interval until stop is called. Replicas
+// sharing their keys check more often and rotate only when the newest key is
+// interval old, so that the rotations of one serve them all.
+func (k *Keyring) RotateEvery(interval time.Duration, onError func(error)) (stop func()) {
+	period, every := interval, time.Duration(0)
+
+	if k.shared() {
+		every = interval
+
+		if period > keyringSyncInterval {
+			period = keyringSyncInterval
+		}
+	}
+
+	ticker := time.NewTicker(period)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, _, err := k.rotate(every); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				ticker.Stop()
+
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// rotate adds a key unless every is not 0 and the newest key is not every old
+// by the time the new one would become active.
+func (k *Keyring) rotate(every time.Duration) (key SigningKey, rotated bool, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+
+	if k.db == nil {
+		if k.rotated, key, rotated, err = k.add(k.rotated, every, now); err != nil {
+			return SigningKey{}, false, fmt.Errorf("error to rotate key: %w", err)
+		}
+
+		return key, rotated, nil
+	}
+
+	var entries []rotatedKey
+
+	err = k.db.Update(func(tx store.Tx) error {
+		value, getErr := tx.Get(keyringKey)
+		if getErr != nil && !errors.Is(getErr, store.ErrNotFound) {
+			return getErr
+		}
+
+		if entries, getErr = decodeRotatedKeys(k.aead, value); getErr != nil {
+			return getErr
+		}
+
+		var addErr error
+		if entries, key, rotated, addErr = k.add(entries, every, now); addErr != nil || !rotated {
+			return addErr
+		}
+
+		value, addErr = encodeRotatedKeys(k.aead, entries)
+		if addErr != nil {
+			return addErr
+		}
+
+		return tx.Set(keyringKey, value, 0)
+	})
+	if err != nil {
+		return SigningKey{}, false, fmt.Errorf("error to rotate key: %w", err)
+	}
+
+	k.rotated, k.loadedAt = entries, now
+	k.version++
+
+	return key, rotated, nil
+}
+
+// add appends a new key to entries, dropping the keys whose grace is over.
+func (k *Keyring) add(
+	entries []rotatedKey,
+	every time.Duration,
+	now time.Time,
+) (updated []rotatedKey, key SigningKey, added bool, err error) {
+	newest := rotatedKey{activeAt: k.createdAt, key: k.static[0]}
+	if len(entries) != 0 {
+		newest = entries[len(entries)-1]
+	}
+
+	activeAt := now.Add(k.publishAhead)
+
+	if every > 0 && newest.activeAt.Add(every).After(activeAt) {
+		return entries, SigningKey{}, false, nil
+	}
+
+	if activeAt.Before(newest.activeAt) {
+		activeAt = newest.activeAt
+	}
+
+	if key, err = newest.key.Generate(); err != nil {
+		return entries, SigningKey{}, false, err
+	}
+
+	// The chain starts with the static key, which is never dropped from it.
+	chain := append(k.chain()[:1], entries...)
+	for len(chain) > 2 && !k.valid(chain, 1, now) {
+		chain = append(chain[:1], chain[2:]...)
+	}
+
+	return append(chain[1:], rotatedKey{activeAt: activeAt, key: key}), key, true, nil
+}
+
+func (k *Keyring) shared() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.db != nil
+}
+
+// chain returns the keys that succeed one another as the active key.
+func (k *Keyring) chain() (chain []rotatedKey) {
+	return append([]rotatedKey{{key: k.static[0]}}, k.rotated...)
+}
+
+func (k *Keyring) active(now time.Time) (key SigningKey) {
+	chain := k.chain()
+
+	for i := len(chain) - 1; i > 0; i-- {
+		if !chain[i].activeAt.After(now) {
+			return chain[i].key
+		}
+	}
+
+	return chain[0].key
+}
+
+// valid reports whether chain[i] still verifies tokens: until the grace period
+// after its successor became active.
+func (k *Keyring) valid(chain []rotatedKey, i int, now time.Time) bool {
+	if i+1 >= len(chain) {
+		return true
+	}
+
+	return now.Before(chain[i+1].activeAt.Add(k.grace))
+}
+
+// sync reloads the shared keys when they may be stale. The store is read
+// without holding the lock and by one caller at a time, so that a slow store
+// only delays that caller; the others go on with the keys loaded last. On
+// failure those keys are kept until the next interval.
+func (k *Keyring) sync() {
+	k.mu.Lock()
+
+	now := time.Now()
+
+	interval := k.publishAhead / 2
+	if interval > keyringSyncInterval {
+		interval = keyringSyncInterval
+	}
+
+	if k.db == nil || k.syncing || now.Sub(k.loadedAt) < interval {
+		k.mu.Unlock()
+
+		return
+	}
+
+	db, aead, version := k.db, k.aead, k.version
+	k.syncing = true
+	k.mu.Unlock()
+
+	rotated, err := fetchRotatedKeys(db, aead)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.syncing = false
+	k.loadedAt = now
+
+	// A rotation since the read is newer than what was read.
+	if err == nil && k.version == version {
+		k.rotated = rotated
+		k.version++
+	}
+}
+
+func (k *Keyring) load(now time.Time) (err error) {
+	rotated, err := fetchRotatedKeys(k.db, k.aead)
+	if err != nil {
+		return err
+	}
+
+	k.rotated, k.loadedAt = rotated, now
+	k.version++
+
+	return nil
+}
+
+func fetchRotatedKeys(db store.TokenStore, aead cipher.AEAD) (entries []rotatedKey, err error) {
+	value, err := db.Get(keyringKey)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("error to load keys: %w", err)
+	}
+
+	if entries, err = decodeRotatedKeys(aead, value); err != nil {
+		return nil, fmt.Errorf("error to load keys: %w", err)
+	}
+
+	return entries, nil
+}
+
+// encodeRotatedKeys seals entries with aead, the nonce first, bound to the key
+// they are stored under.
+func encodeRotatedKeys(aead cipher.AEAD, entries []rotatedKey) (value string, err error) {
+	shared := make([]sharedKey, 0, len(entries))
+
+	for _, entry := range entries {
+		key := sharedKey{ActiveAt: entry.activeAt.Unix()}
+
+		if secret, ok := entry.key.Private.([]byte); ok {
+			key.Secret = secret
+		} else {
+			der, marshalErr := x509.MarshalPKCS8PrivateKey(entry.key.Private)
+			if marshalErr != nil {
+				return "", fmt.Errorf("%w: %s", ErrKeyUnsupported, marshalErr)
+			}
+
+			key.PEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		}
+
+		shared = append(shared, key)
+	}
+
+	data, err := json.Marshal(shared)
+	if err != nil {
+		return "", fmt.Errorf("error to encode keys: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("error to encode keys: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, data, []byte(keyringKey))), nil
+}
+
+func decodeRotatedKeys(aead cipher.AEAD, value string) (entries []rotatedKey, err error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: not sealed", ErrKeyFormat)
+	}
+
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyringKey))
+	if err != nil {
+		return nil, ErrKeyringSecret
+	}
+
+	var shared []sharedKey
+	if err = json.Unmarshal(data, &shared); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyFormat, err)
+	}
+
+	for _, stored := range shared {
+		var key SigningKey
+
+		if stored.Secret != nil {
+			key, err = NewSigningKey(stored.Secret)
+		} else {
+			key, err = ParseSigningKey([]byte(stored.PEM))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, rotatedKey{activeAt: time.Unix(stored.ActiveAt, 0), key: key})
+	}
+
+	return entries, nil
+}
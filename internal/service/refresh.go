@@ -0,0 +1,221 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache/internal/store"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+// TokenPair ...
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	IssuedAt     int64
+	ExpiresAt    int64
+}
+
+const (
+	refreshTokenType   = "refresh"
+	lifeOfRefreshToken = 7 * 24 * time.Hour
+)
+
+// WithRefreshTokenLifetime sets how long a refresh token can be exchanged.
+func WithRefreshTokenLifetime(lifetime time.Duration) Option {
+	return func(s *service) {
+		s.refreshTokenLifetime = lifetime
+	}
+}
+
+// GenerateRefreshToken issues a refresh token that starts a new token family.
+// accessToken, if not empty, is the access token issued along with it, revoked
+// with the family when reuse is detected. Refresh tokens are always signed
+// with server-held keys.
+func (s *service) GenerateRefreshToken(id int, username, email, accessToken string) (token string, err error) {
+	claims := Claims{ID: id, Username: username, Email: email}
+
+	return s.issueRefreshToken(claims, uuid.NewString(), accessToken)
+}
+
+// RefreshToken exchanges a refresh token, which can only be used once, for a
+// new whitelisted access token and a new refresh token of the same family.
+// Using the refresh token and storing the new pair happen in one transaction,
+// so a failed exchange can be retried with the same refresh token.
+func (s *service) RefreshToken(refreshToken string) (pair TokenPair, err error) {
+	claims, err := s.parseToken(refreshToken, nil)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if claims.Type != refreshTokenType || claims.Family == "" {
+		return TokenPair{}, fmt.Errorf("%w: expected a refresh token", ErrTokenType)
+	}
+
+	db, err := s.db(refreshToken)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("error to refresh token: %w", err)
+	}
+
+	if s.migrating() {
+		if _, err = s.migrate(db, refreshTokenPrefix+refreshToken); err != nil {
+			return TokenPair{}, fmt.Errorf("error to refresh token: %w", err)
+		}
+	}
+
+	// The new tokens belong to the tenant of the refresh token.
+	issuer := *s
+	if claims.Tenant != "" {
+		issuer.tenant = claims.Tenant
+	}
+
+	// The exchange continues a session, so it never fails on the limit.
+	issuer.sessionLimit.policy = SessionLimitEvictOldest
+
+	pair.AccessToken, pair.IssuedAt, pair.ExpiresAt, err = issuer.GenerateToken(
+		claims.ID, claims.Username, claims.Email, nil,
+	)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if pair.RefreshToken, err = issuer.signRefreshToken(claims, claims.Family); err != nil {
+		return TokenPair{}, err
+	}
+
+	access, err := issuer.setTokenState(NewSetTokenState(), pair.AccessToken)
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("error to refresh token: %w", err)
+	}
+
+	refresh := NewSetRefreshTokenState(claims.Family, issuer.tokenKey(pair.AccessToken), issuer.refreshTokenLifetime)
+
+	if refresh.session, err = issuer.session(pair.RefreshToken, refreshTokenType); err != nil {
+		return TokenPair{}, fmt.Errorf("error to refresh token: %w", err)
+	}
+
+	var reused bool
+
+	err = db.Update(func(tx store.Tx) error {
+		reused = false
+
+		useErr := useRefreshToken(tx, refreshTokenPrefix+s.tokenKey(refreshToken), &reused)
+		if useErr != nil || reused {
+			return useErr
+		}
+
+		return issuer.exchange(tx, access, refresh, pair)
+	})
+	if err == nil && reused {
+		err = ErrRefreshTokenReused
+	}
+
+	if err != nil {
+		return TokenPair{}, fmt.Errorf("error to refresh token: %w", err)
+	}
+
+	return pair, nil
+}
+
+func (s *service) issueRefreshToken(claims Claims, family, accessToken string) (token string, err error) {
+	if token, err = s.signRefreshToken(claims, family); err != nil {
+		return "", err
+	}
+
+	if accessToken != "" {
+		accessToken = s.tokenKey(accessToken)
+	}
+
+	err = s.ManageToken(NewSetRefreshTokenState(family, accessToken, s.refreshTokenLifetime), token)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *service) signRefreshToken(claims Claims, family string) (token string, err error) {
+	key, err := s.signingKey(nil)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	return signToken(key, s.withTenant(jwt.MapClaims{
+		"id":       claims.ID,
+		"sub":      strconv.Itoa(claims.ID),
+		"username": claims.Username,
+		"email":    claims.Email,
+		"uuid":     uuid.NewString(),
+		"typ":      refreshTokenType,
+		"fam":      family,
+		"iat":      now.Unix(),
+		"nbf":      now.Unix(),
+		"exp":      now.Add(s.refreshTokenLifetime).Unix(),
+	}))
+}
+
+// exchange stores the tokens of pair, issued by an exchange, and indexes
+// their sessions together.
+func (s *service) exchange(tx store.Tx, access SetTokenState, refresh SetRefreshTokenState, pair TokenPair) (err error) {
+	accessKey, refreshKey := s.tokenKey(pair.AccessToken), s.tokenKey(pair.RefreshToken)
+
+	if err = access.set(tx, accessKey); err != nil {
+		return err
+	}
+
+	if err = refresh.addToFamily(tx, refreshKey); err != nil {
+		return err
+	}
+
+	var sessions []Session
+
+	if access.session != nil {
+		sessions = append(sessions, access.sessionOf(accessKey))
+	}
+
+	if refresh.session != nil {
+		sessions = append(sessions, refresh.sessionOf(refreshKey))
+	}
+
+	if len(sessions) == 0 {
+		return nil
+	}
+
+	_, err = addSessions(tx, access.limit, sessions...)
+
+	return err
+}
+
+// checkRefreshToken reports whether the refresh token is stored and unused.
+func (s *service) checkRefreshToken(token string) (check bool, err error) {
+	db, err := s.db(token)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := db.Get(refreshTokenPrefix + s.tokenKey(token))
+	if errors.Is(err, store.ErrNotFound) && s.migrating() {
+		if _, err = s.migrate(db, refreshTokenPrefix+token); err != nil {
+			return false, err
+		}
+
+		result, err = db.Get(refreshTokenPrefix + s.tokenKey(token))
+	}
+
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("error to get refresh token: %w", err)
+	}
+
+	return strings.HasPrefix(result, refreshActive+":"), nil
+}
@@ -3,22 +3,70 @@ package service
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"time"
+
+	"cache/internal/store"
 
-	"github.com/go-redis/redis"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 )
 
 type Service interface {
-	GenerateToken(int, string, string, []byte) string
-	ExtractToken(string, []byte) (int, string, string, error)
+	GenerateToken(int, string, string, []byte) (string, int64, int64, error)
+	ExtractToken(string, []byte) (Claims, error)
 	ManageToken(State, string) error
 	CheckToken(string) (bool, error)
+	PublicKeys() []SigningKey
+	RotateKeys() (SigningKey, error)
+	GenerateRefreshToken(int, string, string, string) (string, error)
+	RefreshToken(string) (TokenPair, error)
+	IntrospectToken(string) (Claims, bool, error)
+	ForTenant(string) Service
+	ForClient(Client) Service
+	StoreToken(string, time.Duration) ([]Session, error)
+	Sessions(int) ([]Session, error)
+	RevokeSessions(int) (int, error)
+}
+
+// Option ...
+type Option func(*service)
+
+// Claims ...
+type Claims struct {
+	Username  string
+	Email     string
+	Subject   string
+	TokenID   string
+	Type      string
+	Family    string
+	Scope     string
+	ClientID  string
+	Tenant    string
+	ID        int
+	IssuedAt  int64
+	ExpiresAt int64
 }
 
 // service ...
 type service struct {
-	DB *redis.Client
+	DB            store.TokenStore
+	keyring       *Keyring
+	pepper        []byte
+	keyPrefix     string
+	tenant        string
+	client        Client
+	sessionLimit  sessionLimit
+	clientSecrets bool
+	tokenLifetime time.Duration
+	leeway        time.Duration
+
+	refreshTokenLifetime time.Duration
+	minTokenTTL          time.Duration
+	maxTokenTTL          time.Duration
+	idleTimeout          time.Duration
+	absoluteTimeout      time.Duration
+	rawKeyMigration      bool
 }
 
 const (
@@ -28,59 +76,184 @@ const (
 var (
 	ErrUnexpectedSigningMethod = errors.New("unexpected signing method")
 	ErrClaims                  = errors.New("error to claims")
+	ErrTokenExpired            = errors.New("token is expired")
+	ErrTokenNotValidYet        = errors.New("token is not valid yet")
+	ErrUnknownKeyID            = errors.New("unknown key id")
+	ErrNoKeyring               = errors.New("no keyring configured")
+	ErrClientSecret            = errors.New("client supplied secrets are not allowed")
+	ErrNoSecret                = errors.New("no secret to verify token")
+	ErrTokenType               = errors.New("wrong token type")
 )
 
 // GetService ...
-func GetService(db *redis.Client) *service {
-	return &service{db}
+func GetService(db store.TokenStore, opts ...Option) *service {
+	s := &service{
+		DB:            db,
+		tokenLifetime: time.Minute * time.Duration(lifeOfToken),
+
+		refreshTokenLifetime: lifeOfRefreshToken,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// WithTokenLifetime sets how long a generated token is valid (exp - iat).
+func WithTokenLifetime(lifetime time.Duration) Option {
+	return func(s *service) {
+		s.tokenLifetime = lifetime
+	}
+}
+
+// WithLeeway sets the clock skew tolerated when validating exp, nbf and iat.
+func WithLeeway(leeway time.Duration) Option {
+	return func(s *service) {
+		s.leeway = leeway
+	}
+}
+
+// WithSigningKeys makes GenerateToken sign with the first key; all keys are
+// accepted by ExtractToken. The keys never expire.
+func WithSigningKeys(keys ...SigningKey) Option {
+	return func(s *service) {
+		if len(keys) != 0 {
+			s.keyring = NewKeyring(0, keys[0], keys[1:]...)
+		}
+	}
+}
+
+// WithKeyring signs and verifies tokens with the keys held by keyring.
+func WithKeyring(keyring *Keyring) Option {
+	return func(s *service) {
+		s.keyring = keyring
+	}
+}
+
+// WithClientSecrets enables the legacy mode where callers pass the HMAC secret
+// with each request. Without it a non-empty secret is rejected with
+// ErrClientSecret and only server-held keys are used.
+func WithClientSecrets() Option {
+	return func(s *service) {
+		s.clientSecrets = true
+	}
 }
 
 // GenerateToken ...
-func (service) GenerateToken(id int, username, email string, secret []byte) (token string) {
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+func (s service) GenerateToken(
+	id int,
+	username, email string,
+	secret []byte,
+) (token string, issuedAt, expiresAt int64, err error) {
+	now := time.Now()
+	issuedAt = now.Unix()
+	expiresAt = now.Add(s.tokenLifetime).Unix()
+
+	if err = checkTenant(s.tenant); err != nil {
+		return "", 0, 0, err
+	}
+
+	key, err := s.signingKey(secret)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	token, err = signToken(key, s.withTenant(jwt.MapClaims{
 		"id":       id,
+		"sub":      strconv.Itoa(id),
 		"username": username,
 		"email":    email,
 		"uuid":     uuid.NewString(),
-	})
-
-	token, _ = t.SignedString(secret)
+		"iat":      issuedAt,
+		"nbf":      issuedAt,
+		"exp":      expiresAt,
+	}))
+	if err != nil {
+		return "", 0, 0, err
+	}
 
-	return token
+	return token, issuedAt, expiresAt, nil
 }
 
 // ExtractToken ...
-func (service) ExtractToken(token string, secret []byte) (id int, username, email string, err error) {
-	t, err := jwt.Parse(token, KeyFunc(secret))
+func (s service) ExtractToken(token string, secret []byte) (claims Claims, err error) {
+	claims, err = s.parseToken(token, secret)
 	if err != nil {
-		return 0, "", "", fmt.Errorf("error to extract token: %w", err)
+		return Claims{}, err
+	}
+
+	if claims.Type != "" {
+		return Claims{}, fmt.Errorf("%w: %s token", ErrTokenType, claims.Type)
 	}
 
-	claims, _ := t.Claims.(jwt.MapClaims)
+	return claims, nil
+}
 
-	idAux, ok := claims["id"].(float64)
-	if !ok {
-		return 0, "", "", fmt.Errorf("%w: claims['id'] isn't of type float64", ErrClaims)
+// parseToken verifies the signature and time claims of token.
+func (s service) parseToken(token string, secret []byte) (claims Claims, err error) {
+	if len(secret) != 0 && !s.clientSecrets {
+		return Claims{}, ErrClientSecret
 	}
 
-	id = int(idAux)
+	parser := jwt.Parser{SkipClaimsValidation: true}
 
-	username, ok = claims["username"].(string)
-	if !ok {
-		return 0, "", "", fmt.Errorf("%w: claims['username'] isn't of type string", ErrClaims)
+	t, err := parser.Parse(token, KeyFunc(secret, s.verificationKeys()...))
+	if err != nil {
+		// jwt.ValidationError does not unwrap, so surface the KeyFunc or
+		// signature error. Malformed tokens keep theirs, which reads the same.
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Inner != nil &&
+			validationErr.Errors&jwt.ValidationErrorMalformed == 0 {
+			err = validationErr.Inner
+		}
+
+		return Claims{}, fmt.Errorf("error to extract token: %w", err)
 	}
 
-	email, ok = claims["email"].(string)
-	if !ok {
-		return 0, "", "", fmt.Errorf("%w: claims['email'] isn't of type string", ErrClaims)
+	mapClaims, _ := t.Claims.(jwt.MapClaims)
+
+	claims, err = parseClaims(mapClaims)
+	if err != nil {
+		return Claims{}, err
 	}
 
-	return id, username, email, nil
+	if err = s.validateTime(mapClaims); err != nil {
+		return Claims{}, err
+	}
+
+	return claims, nil
 }
 
-// ManageToken ...
+// ManageToken applies st to the key token is stored under, in the namespace
+// of its tenant.
 func (s *service) ManageToken(st State, token string) (err error) {
-	err = st.ManageToken(s.DB, token)
+	db, err := s.db(token)
+	if err != nil {
+		return fmt.Errorf("error when managing token: %w", err)
+	}
+
+	switch state := st.(type) {
+	case SetTokenState:
+		if st, err = s.setTokenState(state, token); err != nil {
+			return fmt.Errorf("error when managing token: %w", err)
+		}
+	case SetRefreshTokenState:
+		if state.session, err = s.session(token, refreshTokenType); err != nil {
+			return fmt.Errorf("error when managing token: %w", err)
+		}
+
+		st = state
+	case DeleteTokenState, UseRefreshTokenState:
+		if s.migrating() {
+			if _, err = s.migrate(db, token, refreshTokenPrefix+token); err != nil {
+				return fmt.Errorf("error when managing token: %w", err)
+			}
+		}
+	}
+
+	err = st.ManageToken(db, s.tokenKey(token))
 	if err != nil {
 		return fmt.Errorf("error when managing token: %w", err)
 	}
@@ -88,30 +261,256 @@ func (s *service) ManageToken(st State, token string) (err error) {
 	return nil
 }
 
-// CheckToken ...
+// setTokenState completes st with what the service knows of token: its
+// session, the session limit and how long to store it for.
+func (s *service) setTokenState(st SetTokenState, token string) (prepared SetTokenState, err error) {
+	// A token that does not verify is whitelisted but not indexed, unless it
+	// is expired or would count toward a session limit: its user id cannot
+	// be trusted to pick whose sessions to evict.
+	st.session, err = s.session(token, accessTokenType)
+	if err != nil && (s.sessionLimit.max > 0 || errors.Is(err, ErrTokenExpired)) {
+		return SetTokenState{}, err
+	}
+
+	st.limit = s.sessionLimit
+
+	if st.ttl, err = s.tokenTTL(st.session, st.ttl); err != nil {
+		return SetTokenState{}, err
+	}
+
+	if s.idleTimeout > 0 {
+		st.deadline = s.deadline(st.ttl)
+
+		if s.idleTimeout < st.ttl {
+			st.ttl = s.idleTimeout
+		}
+	}
+
+	return st, nil
+}
+
+// CheckToken reports whether token is whitelisted. With sliding expiration it
+// also extends its TTL.
 func (s service) CheckToken(token string) (check bool, err error) {
-	result, err := s.DB.Get(token).Result()
+	db, err := s.db(token)
+	if errors.Is(err, ErrReservedKey) {
+		return false, nil
+	}
+
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return false, nil
-		}
+		return false, err
+	}
 
+	if s.idleTimeout > 0 {
+		check, err = s.slide(db, s.tokenKey(token))
+	} else {
+		check, err = isWhitelisted(db, s.tokenKey(token))
+	}
+
+	if err != nil {
 		return false, fmt.Errorf("error to get token: %w", err)
 	}
 
-	if result == "1" {
-		check = true
+	if !check && s.migrating() {
+		return s.migrate(db, token)
 	}
 
 	return check, nil
 }
 
-func KeyFunc(secret []byte) func(token *jwt.Token) (any, error) {
+// isWhitelisted reports whether key holds a whitelisted token, as opposed to
+// nothing or another record.
+func isWhitelisted(db store.TokenStore, key string) (check bool, err error) {
+	value, err := db.Get(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return whitelisted(value), nil
+}
+
+// PublicKeys returns the asymmetric keys tokens may be verified with.
+func (s service) PublicKeys() (keys []SigningKey) {
+	for _, key := range s.verificationKeys() {
+		if key.Public != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// RotateKeys ...
+func (s service) RotateKeys() (key SigningKey, err error) {
+	if s.keyring == nil {
+		return SigningKey{}, ErrNoKeyring
+	}
+
+	return s.keyring.Rotate()
+}
+
+// KeyFunc looks the key up by the kid header when the token has one.
+// Otherwise it returns secret, if any, for HMAC tokens, or else the first key
+// whose algorithm matches the token header.
+func KeyFunc(secret []byte, keys ...SigningKey) func(token *jwt.Token) (any, error) {
 	return func(token *jwt.Token) (any, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrUnexpectedSigningMethod
+		if keyID, ok := token.Header["kid"].(string); ok {
+			for _, key := range keys {
+				if key.KeyID == keyID && key.Method.Alg() == token.Method.Alg() {
+					return key.verificationKey(), nil
+				}
+			}
+
+			return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, keyID)
+		}
+
+		_, isHMAC := token.Method.(*jwt.SigningMethodHMAC)
+		if isHMAC && len(secret) != 0 {
+			return secret, nil
+		}
+
+		for _, key := range keys {
+			if key.Method.Alg() == token.Method.Alg() {
+				return key.verificationKey(), nil
+			}
+		}
+
+		if isHMAC {
+			return nil, ErrNoSecret
+		}
+
+		return nil, ErrUnexpectedSigningMethod
+	}
+}
+
+func signToken(key SigningKey, mapClaims jwt.MapClaims) (token string, err error) {
+	t := jwt.NewWithClaims(key.Method, mapClaims)
+
+	if key.KeyID != "" {
+		t.Header["kid"] = key.KeyID
+	}
+
+	token, err = t.SignedString(key.Private)
+	if err != nil {
+		return "", fmt.Errorf("error to sign token: %w", err)
+	}
+
+	return token, nil
+}
+
+// signingKey picks the client secret in legacy mode, else the active key.
+func (s service) signingKey(secret []byte) (key SigningKey, err error) {
+	if len(secret) != 0 {
+		if !s.clientSecrets {
+			return SigningKey{}, ErrClientSecret
+		}
+
+		return SigningKey{Method: jwt.SigningMethodHS256, Private: secret}, nil
+	}
+
+	if s.keyring == nil {
+		return SigningKey{}, ErrNoKeyring
+	}
+
+	return s.keyring.Active(), nil
+}
+
+func (s service) verificationKeys() (keys []SigningKey) {
+	if s.keyring == nil {
+		return nil
+	}
+
+	return s.keyring.Keys()
+}
+
+func parseClaims(mapClaims jwt.MapClaims) (claims Claims, err error) {
+	idAux, ok := mapClaims["id"].(float64)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: claims['id'] isn't of type float64", ErrClaims)
+	}
+
+	claims.ID = int(idAux)
+
+	claims.Username, ok = mapClaims["username"].(string)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: claims['username'] isn't of type string", ErrClaims)
+	}
+
+	claims.Email, ok = mapClaims["email"].(string)
+	if !ok {
+		return Claims{}, fmt.Errorf("%w: claims['email'] isn't of type string", ErrClaims)
+	}
+
+	if claims.IssuedAt, err = numericDate(mapClaims, "iat"); err != nil {
+		return Claims{}, err
+	}
+
+	if claims.ExpiresAt, err = numericDate(mapClaims, "exp"); err != nil {
+		return Claims{}, err
+	}
+
+	// Optional claims: sub and uuid are set on every token generated here,
+	// typ and fam only on refresh tokens, tenant on tokens generated for a
+	// tenant, scope and client_id never.
+	claims.Subject, _ = mapClaims["sub"].(string)
+	claims.TokenID, _ = mapClaims["uuid"].(string)
+	claims.Type, _ = mapClaims["typ"].(string)
+	claims.Family, _ = mapClaims["fam"].(string)
+	claims.Scope, _ = mapClaims["scope"].(string)
+	claims.ClientID, _ = mapClaims["client_id"].(string)
+	claims.Tenant, _ = mapClaims[tenantClaim].(string)
+
+	if claims.Subject == "" {
+		claims.Subject = strconv.Itoa(claims.ID)
+	}
+
+	return claims, nil
+}
+
+// validateTime rejects tokens whose exp is in the past or whose nbf or iat is
+// in the future, allowing for the configured leeway in both directions.
+func (s service) validateTime(mapClaims jwt.MapClaims) (err error) {
+	now := time.Now()
+
+	exp, err := numericDate(mapClaims, "exp")
+	if err != nil {
+		return err
+	}
+
+	if exp != 0 && now.Add(-s.leeway).Unix() > exp {
+		return fmt.Errorf("%w: expired at %s", ErrTokenExpired, time.Unix(exp, 0).UTC().Format(time.RFC3339))
+	}
+
+	var date int64
+
+	for _, name := range []string{"nbf", "iat"} {
+		if date, err = numericDate(mapClaims, name); err != nil {
+			return err
+		}
+
+		if date != 0 && now.Add(s.leeway).Unix() < date {
+			return fmt.Errorf("%w: claims['%s'] is in the future", ErrTokenNotValidYet, name)
 		}
+	}
+
+	return nil
+}
 
-		return secret, nil
+// numericDate returns the claim as unix seconds, or 0 when it is absent.
+func numericDate(mapClaims jwt.MapClaims, name string) (date int64, err error) {
+	value, ok := mapClaims[name]
+	if !ok {
+		return 0, nil
 	}
+
+	dateAux, ok := value.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%w: claims['%s'] isn't of type float64", ErrClaims, name)
+	}
+
+	return int64(dateAux), nil
 }
@@ -1,36 +1,148 @@
 package service_test
 
 import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"cache/internal/entity/mock"
 	"cache/internal/service"
+	"cache/internal/store"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
+// subjectStore records the subjects given to its transactions, like the
+// PostgresStore does.
+type subjectStore struct {
+	store.TokenStore
+	subjects map[string]string
+}
+
+type subjectTx struct {
+	store.Tx
+	subjects map[string]string
+}
+
+// failingStore fails the transactions writing a key without prefix, the
+// access tokens, while fail is set.
+type failingStore struct {
+	store.TokenStore
+	fail *bool
+}
+
+type failingTx struct {
+	store.Tx
+	fail bool
+}
+
+// unlistedStore cannot list its keys, like the PostgresStore.
+type unlistedStore struct {
+	store.TokenStore
+}
+
+// slowStore holds every Get, once slow is set, until release is closed.
+type slowStore struct {
+	store.TokenStore
+	reading chan struct{}
+	release chan struct{}
+	slow    bool
+}
+
 func TestGenerateToken(t *testing.T) {
 	t.Parallel()
 
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
 	for _, tt := range []struct {
+		inPrivateKey        any
 		name                string
 		inUsername, inEmail string
 		outToken, outErr    string
+		outAlg              string
 		inSecret            []byte
 		inID                int
+		inLifetime          time.Duration
+		outLifetime         int64
 	}{
 		{
-			name:       mock.NameNoError,
-			inID:       mock.IDTest,
-			inUsername: mock.UsernameTest,
-			inEmail:    mock.EmailTest,
-			inSecret:   []byte(mock.SecretTest),
-			outToken:   "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.",
-			outErr:     "",
+			name:        mock.NameNoError,
+			inID:        mock.IDTest,
+			inUsername:  mock.UsernameTest,
+			inEmail:     mock.EmailTest,
+			inSecret:    []byte(mock.SecretTest),
+			outToken:    "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.",
+			outLifetime: int64((10 * time.Minute).Seconds()),
+			outErr:      "",
+		},
+		{
+			name:        mock.NameNoError + "Lifetime",
+			inID:        mock.IDTest,
+			inUsername:  mock.UsernameTest,
+			inEmail:     mock.EmailTest,
+			inSecret:    []byte(mock.SecretTest),
+			inLifetime:  time.Hour,
+			outToken:    "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.",
+			outLifetime: int64(time.Hour.Seconds()),
+			outErr:      "",
+		},
+		{
+			name:         mock.NameNoError + "RS256",
+			inID:         mock.IDTest,
+			inUsername:   mock.UsernameTest,
+			inEmail:      mock.EmailTest,
+			inPrivateKey: rsaKey,
+			outAlg:       jwt.SigningMethodRS256.Alg(),
+			outLifetime:  int64((10 * time.Minute).Seconds()),
+			outErr:       "",
+		},
+		{
+			name:         mock.NameNoError + "ES256",
+			inID:         mock.IDTest,
+			inUsername:   mock.UsernameTest,
+			inEmail:      mock.EmailTest,
+			inPrivateKey: ecKey,
+			outAlg:       jwt.SigningMethodES256.Alg(),
+			outLifetime:  int64((10 * time.Minute).Seconds()),
+			outErr:       "",
+		},
+		{
+			name:         mock.NameNoError + "EdDSA",
+			inID:         mock.IDTest,
+			inUsername:   mock.UsernameTest,
+			inEmail:      mock.EmailTest,
+			inPrivateKey: edKey,
+			outAlg:       jwt.SigningMethodEdDSA.Alg(),
+			outLifetime:  int64((10 * time.Minute).Seconds()),
+			outErr:       "",
 		},
 	} {
 		tt := tt
@@ -38,6 +150,9 @@ func TestGenerateToken(t *testing.T) {
 			t.Parallel()
 
 			var result string
+			var issuedAt, expiresAt int64
+
+			opts := []service.Option{service.WithClientSecrets()}
 
 			mr, err := miniredis.Run()
 			if err != nil {
@@ -46,11 +161,46 @@ func TestGenerateToken(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			if tt.inLifetime != 0 {
+				opts = append(opts, service.WithTokenLifetime(tt.inLifetime))
+			}
+
+			if tt.inPrivateKey != nil {
+				key, err := service.NewSigningKey(tt.inPrivateKey)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				opts = append(opts, service.WithSigningKeys(key))
+			}
+
+			svc := service.GetService(store.NewRedisStore(client), opts...)
 
-			result = svc.GenerateToken(tt.inID, tt.inUsername, tt.inEmail, tt.inSecret)
+			result, issuedAt, expiresAt, err = svc.GenerateToken(tt.inID, tt.inUsername, tt.inEmail, tt.inSecret)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
 
 			assert.Contains(t, result, tt.outToken)
+			assert.Equal(t, tt.outLifetime, expiresAt-issuedAt)
+
+			if tt.outAlg != "" {
+				token, _, err := new(jwt.Parser).ParseUnverified(result, jwt.MapClaims{})
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				assert.Equal(t, tt.outAlg, token.Header["alg"])
+				assert.NotEmpty(t, token.Header["kid"])
+			}
+
+			claims, err := svc.ExtractToken(result, tt.inSecret)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			assert.Equal(t, issuedAt, claims.IssuedAt)
+			assert.Equal(t, expiresAt, claims.ExpiresAt)
 		})
 	}
 }
@@ -86,11 +236,50 @@ func TestExtractToken(t *testing.T) {
 		"uuid":     uuid.NewString(),
 	})
 
+	tokenExpired := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       mock.IDTest,
+		"username": mock.UsernameTest,
+		"email":    mock.EmailTest,
+		"uuid":     uuid.NewString(),
+		"exp":      time.Now().Add(-time.Minute).Unix(),
+	})
+
+	tokenNotValidYet := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       mock.IDTest,
+		"username": mock.UsernameTest,
+		"email":    mock.EmailTest,
+		"uuid":     uuid.NewString(),
+		"nbf":      time.Now().Add(time.Minute).Unix(),
+	})
+
+	tokenBadExp := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       mock.IDTest,
+		"username": mock.UsernameTest,
+		"email":    mock.EmailTest,
+		"uuid":     uuid.NewString(),
+		"exp":      "badExp",
+	})
+
 	tokenSigned, err := token.SignedString([]byte(mock.SecretTest))
 	if err != nil {
 		assert.Error(t, err)
 	}
 
+	tokenSignedExpired, err := tokenExpired.SignedString([]byte(mock.SecretTest))
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	tokenSignedNotValidYet, err := tokenNotValidYet.SignedString([]byte(mock.SecretTest))
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	tokenSignedBadExp, err := tokenBadExp.SignedString([]byte(mock.SecretTest))
+	if err != nil {
+		assert.Error(t, err)
+	}
+
 	tokenSignedBadID, err := tokenBadID.SignedString([]byte(mock.SecretTest))
 	if err != nil {
 		assert.Error(t, err)
@@ -112,6 +301,7 @@ func TestExtractToken(t *testing.T) {
 		outUsername, outEmail, outErr string
 		inSecret                      []byte
 		outID                         int
+		inLeeway                      time.Duration
 	}{
 		{
 			name:        mock.NameNoError,
@@ -158,13 +348,60 @@ func TestExtractToken(t *testing.T) {
 			outEmail:    "",
 			outErr:      "claims['email'] isn't of type string",
 		},
+		{
+			name:        "ErrorClaimsExp",
+			inToken:     tokenSignedBadExp,
+			inSecret:    []byte(mock.SecretTest),
+			outID:       0,
+			outUsername: "",
+			outEmail:    "",
+			outErr:      "claims['exp'] isn't of type float64",
+		},
+		{
+			name:        "ErrorTokenExpired",
+			inToken:     tokenSignedExpired,
+			inSecret:    []byte(mock.SecretTest),
+			outID:       0,
+			outUsername: "",
+			outEmail:    "",
+			outErr:      service.ErrTokenExpired.Error(),
+		},
+		{
+			name:        "ErrorTokenNotValidYet",
+			inToken:     tokenSignedNotValidYet,
+			inSecret:    []byte(mock.SecretTest),
+			outID:       0,
+			outUsername: "",
+			outEmail:    "",
+			outErr:      service.ErrTokenNotValidYet.Error(),
+		},
+		{
+			name:        mock.NameNoError + "LeewayExpired",
+			inToken:     tokenSignedExpired,
+			inSecret:    []byte(mock.SecretTest),
+			inLeeway:    2 * time.Minute,
+			outID:       mock.IDTest,
+			outUsername: mock.UsernameTest,
+			outEmail:    mock.EmailTest,
+			outErr:      "",
+		},
+		{
+			name:        mock.NameNoError + "LeewayNotValidYet",
+			inToken:     tokenSignedNotValidYet,
+			inSecret:    []byte(mock.SecretTest),
+			inLeeway:    2 * time.Minute,
+			outID:       mock.IDTest,
+			outUsername: mock.UsernameTest,
+			outEmail:    mock.EmailTest,
+			outErr:      "",
+		},
 	} {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			var resultID int
-			var resultUsername, resultEmail, resultErr string
+			var result service.Claims
+			var resultErr string
 			var mr *miniredis.Miniredis
 
 			mr, err = miniredis.Run()
@@ -174,22 +411,22 @@ func TestExtractToken(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			svc := service.GetService(store.NewRedisStore(client), service.WithClientSecrets(), service.WithLeeway(tt.inLeeway))
 
-			resultID, resultUsername, resultEmail, err = svc.ExtractToken(tt.inToken, tt.inSecret)
+			result, err = svc.ExtractToken(tt.inToken, tt.inSecret)
 			if err != nil {
 				resultErr = err.Error()
 			}
 
-			if tt.name == mock.NameNoError {
+			if tt.outErr == "" {
 				assert.Empty(t, resultErr)
 			} else {
 				assert.Contains(t, resultErr, tt.outErr)
 			}
 
-			assert.Equal(t, tt.outID, resultID, "they should be equal")
-			assert.Equal(t, tt.outUsername, resultUsername, "they should be equal")
-			assert.Equal(t, tt.outEmail, resultEmail, "they should be equal")
+			assert.Equal(t, tt.outID, result.ID, "they should be equal")
+			assert.Equal(t, tt.outUsername, result.Username, "they should be equal")
+			assert.Equal(t, tt.outEmail, result.Email, "they should be equal")
 		})
 	}
 }
@@ -250,7 +487,7 @@ func TestManageToken(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			svc := service.GetService(store.NewRedisStore(client))
 
 			if tt.name == mock.NameErrorRedisClose {
 				svc.DB.Close()
@@ -321,7 +558,7 @@ func TestCheckToken(t *testing.T) {
 
 			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
 
-			svc := service.GetService(client)
+			svc := service.GetService(store.NewRedisStore(client))
 
 			if tt.in != "" {
 				err = svc.ManageToken(service.NewSetTokenState(), tt.in)
@@ -353,23 +590,106 @@ func TestCheckToken(t *testing.T) {
 func TestKeyFunc(t *testing.T) {
 	t.Parallel()
 
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	signingKey, err := service.NewSigningKey(ecKey)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
 	for _, tt := range []struct {
+		inMethod            jwt.SigningMethod
+		outPublic           any
 		name                string
 		inUsername, inEmail string
 		outErr              string
 		inSecret            []byte
+		inKeyID             string
 		outSecret           []byte
+		inKeys              []service.SigningKey
 		inID                int
 	}{
 		{
 			name:       "Error",
+			inMethod:   jwt.SigningMethodPS256,
+			inSecret:   []byte(mock.SecretTest),
+			inID:       mock.IDTest,
+			inUsername: mock.UsernameTest,
+			inEmail:    mock.EmailTest,
+			outSecret:  []byte(nil),
+			outErr:     service.ErrUnexpectedSigningMethod.Error(),
+		},
+		{
+			name:       "ErrorAlgorithmWithoutKey",
+			inMethod:   jwt.SigningMethodES384,
 			inSecret:   []byte(mock.SecretTest),
+			inKeys:     []service.SigningKey{signingKey},
 			inID:       mock.IDTest,
 			inUsername: mock.UsernameTest,
 			inEmail:    mock.EmailTest,
 			outSecret:  []byte(nil),
 			outErr:     service.ErrUnexpectedSigningMethod.Error(),
 		},
+		{
+			name:       mock.NameNoError,
+			inMethod:   jwt.SigningMethodHS256,
+			inSecret:   []byte(mock.SecretTest),
+			inKeys:     []service.SigningKey{signingKey},
+			inID:       mock.IDTest,
+			inUsername: mock.UsernameTest,
+			inEmail:    mock.EmailTest,
+			outSecret:  []byte(mock.SecretTest),
+			outErr:     "",
+		},
+		{
+			name:       "ErrorNoSecret",
+			inMethod:   jwt.SigningMethodHS256,
+			inSecret:   nil,
+			inKeys:     []service.SigningKey{signingKey},
+			inID:       mock.IDTest,
+			inUsername: mock.UsernameTest,
+			inEmail:    mock.EmailTest,
+			outSecret:  []byte(nil),
+			outErr:     service.ErrNoSecret.Error(),
+		},
+		{
+			name:       "ErrorUnknownKeyID",
+			inMethod:   jwt.SigningMethodES256,
+			inKeyID:    "unknown",
+			inSecret:   []byte(mock.SecretTest),
+			inKeys:     []service.SigningKey{signingKey},
+			inID:       mock.IDTest,
+			inUsername: mock.UsernameTest,
+			inEmail:    mock.EmailTest,
+			outSecret:  []byte(nil),
+			outErr:     service.ErrUnknownKeyID.Error(),
+		},
+		{
+			name:       mock.NameNoError + "KeyID",
+			inMethod:   jwt.SigningMethodES256,
+			inKeyID:    signingKey.KeyID,
+			inSecret:   []byte(mock.SecretTest),
+			inKeys:     []service.SigningKey{signingKey},
+			inID:       mock.IDTest,
+			inUsername: mock.UsernameTest,
+			inEmail:    mock.EmailTest,
+			outPublic:  &ecKey.PublicKey,
+			outErr:     "",
+		},
+		{
+			name:       mock.NameNoError + "ES256",
+			inMethod:   jwt.SigningMethodES256,
+			inSecret:   []byte(mock.SecretTest),
+			inKeys:     []service.SigningKey{signingKey},
+			inID:       mock.IDTest,
+			inUsername: mock.UsernameTest,
+			inEmail:    mock.EmailTest,
+			outPublic:  &ecKey.PublicKey,
+			outErr:     "",
+		},
 	} {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
@@ -378,27 +698,37 @@ func TestKeyFunc(t *testing.T) {
 			var result []byte
 			var resultErr string
 
-			kf := service.KeyFunc(tt.inSecret)
+			kf := service.KeyFunc(tt.inSecret, tt.inKeys...)
 
 			// generateToken.
-			token := jwt.NewWithClaims(jwt.SigningMethodPS256, jwt.MapClaims{
+			token := jwt.NewWithClaims(tt.inMethod, jwt.MapClaims{
 				"id":       tt.inID,
 				"username": tt.inUsername,
 				"email":    tt.inEmail,
 				"uuid":     uuid.NewString(),
 			})
 
+			if tt.inKeyID != "" {
+				token.Header["kid"] = tt.inKeyID
+			}
+
 			res, err := kf(token)
 			if err != nil {
 				resultErr = err.Error()
 			}
 
-			if tt.name == mock.NameNoError {
+			if tt.outErr == "" {
 				assert.Empty(t, resultErr)
 			} else {
 				assert.Contains(t, resultErr, tt.outErr)
 			}
 
+			if tt.outPublic != nil {
+				assert.Equal(t, tt.outPublic, res)
+
+				return
+			}
+
 			result, ok := res.([]byte)
 			if resultErr == "" {
 				if !ok {
@@ -410,3 +740,1665 @@ func TestKeyFunc(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadSigningKey(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	files := map[string][]byte{
+		"rsa.pem":   pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+		"ec.pem":    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+		"ed.pem":    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER}),
+		"nopem.pem": []byte("not a key"),
+		"bad.pem":   pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("bad")}),
+	}
+
+	for name, data := range files {
+		if err = os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			assert.Error(t, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		in     string
+		outAlg string
+		outErr string
+	}{
+		{
+			name:   mock.NameNoError + "RSA",
+			in:     "rsa.pem",
+			outAlg: jwt.SigningMethodRS256.Alg(),
+			outErr: "",
+		},
+		{
+			name:   mock.NameNoError + "ECDSA",
+			in:     "ec.pem",
+			outAlg: jwt.SigningMethodES384.Alg(),
+			outErr: "",
+		},
+		{
+			name:   mock.NameNoError + "Ed25519",
+			in:     "ed.pem",
+			outAlg: jwt.SigningMethodEdDSA.Alg(),
+			outErr: "",
+		},
+		{
+			name:   "ErrorNotFound",
+			in:     "missing.pem",
+			outErr: "error to read key file",
+		},
+		{
+			name:   "ErrorNoPEM",
+			in:     "nopem.pem",
+			outErr: service.ErrKeyFormat.Error(),
+		},
+		{
+			name:   "ErrorBadKey",
+			in:     "bad.pem",
+			outErr: service.ErrKeyFormat.Error(),
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			key, err := service.LoadSigningKey(filepath.Join(dir, tt.in))
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			if tt.outErr == "" {
+				assert.Empty(t, resultErr)
+				assert.Equal(t, tt.outAlg, key.Method.Alg())
+				assert.NotNil(t, key.Public)
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+		})
+	}
+}
+
+func TestPublicKeys(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	for _, tt := range []struct {
+		inPrivateKey any
+		outJWK       map[string]string
+		name         string
+	}{
+		{
+			name:         mock.NameNoError + "RSA",
+			inPrivateKey: rsaKey,
+			outJWK: map[string]string{
+				"kty": "RSA",
+				"alg": jwt.SigningMethodRS256.Alg(),
+				"use": "sig",
+				"e":   "AQAB",
+			},
+		},
+		{
+			name:         mock.NameNoError + "ECDSA",
+			inPrivateKey: ecKey,
+			outJWK: map[string]string{
+				"kty": "EC",
+				"alg": jwt.SigningMethodES256.Alg(),
+				"use": "sig",
+				"crv": "P-256",
+			},
+		},
+		{
+			name:         mock.NameNoError + "Ed25519",
+			inPrivateKey: edKey,
+			outJWK: map[string]string{
+				"kty": "OKP",
+				"alg": jwt.SigningMethodEdDSA.Alg(),
+				"use": "sig",
+				"crv": "Ed25519",
+				"x":   base64.RawURLEncoding.EncodeToString(edPublic),
+			},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := service.NewSigningKey(tt.inPrivateKey)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			svc := service.GetService(nil, service.WithSigningKeys(key))
+
+			keys := svc.PublicKeys()
+			if assert.Len(t, keys, 1) {
+				jwk := keys[0].JWK()
+
+				for name, value := range tt.outJWK {
+					assert.Equal(t, value, jwk[name], name)
+				}
+
+				assert.Equal(t, key.KeyID, jwk["kid"])
+			}
+		})
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name           string
+		inAlg          string
+		inGrace        time.Duration
+		outKeysRetired int
+	}{
+		{
+			name:           mock.NameNoError + "HS256",
+			inAlg:          jwt.SigningMethodHS256.Alg(),
+			inGrace:        time.Minute,
+			outKeysRetired: 1,
+		},
+		{
+			name:           mock.NameNoError + "ES256",
+			inAlg:          jwt.SigningMethodES256.Alg(),
+			inGrace:        time.Minute,
+			outKeysRetired: 1,
+		},
+		{
+			name:           mock.NameNoError + "WithoutGrace",
+			inAlg:          jwt.SigningMethodEdDSA.Alg(),
+			inGrace:        0,
+			outKeysRetired: 0,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := service.GenerateSigningKey(tt.inAlg)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			keyring := service.NewKeyring(tt.inGrace, key)
+			svc := service.GetService(nil, service.WithKeyring(keyring))
+
+			oldToken, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			newKey, err := svc.RotateKeys()
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			assert.NotEqual(t, key.KeyID, newKey.KeyID)
+			assert.Equal(t, tt.inAlg, newKey.Method.Alg())
+			assert.Equal(t, newKey.KeyID, keyring.Active().KeyID)
+			assert.Len(t, keyring.Keys(), 1+tt.outKeysRetired)
+
+			newToken, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(newToken, jwt.MapClaims{})
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			assert.Equal(t, newKey.KeyID, parsed.Header["kid"])
+
+			_, err = svc.ExtractToken(newToken, nil)
+			assert.NoError(t, err)
+
+			_, err = svc.ExtractToken(oldToken, nil)
+			if tt.outKeysRetired == 0 {
+				assert.ErrorIs(t, err, service.ErrUnknownKeyID)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	t.Parallel()
+
+	svc := service.GetService(nil)
+
+	_, err := svc.RotateKeys()
+	assert.ErrorIs(t, err, service.ErrNoKeyring)
+}
+
+func TestRotateEvery(t *testing.T) {
+	t.Parallel()
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodHS256.Alg())
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	keyring := service.NewKeyring(time.Minute, key)
+
+	stop := keyring.RotateEvery(time.Millisecond, nil)
+	defer stop()
+
+	assert.Eventually(t, func() bool {
+		return keyring.Active().KeyID != key.KeyID
+	}, time.Second, time.Millisecond)
+}
+
+func TestKeyringShare(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name           string
+		inAlg          string
+		inPublishAhead time.Duration
+	}{
+		{
+			name:  mock.NameNoError + "ES256",
+			inAlg: jwt.SigningMethodES256.Alg(),
+		},
+		{
+			name:  mock.NameNoError + "HS256",
+			inAlg: jwt.SigningMethodHS256.Alg(),
+		},
+		{
+			name:           "PublishAhead",
+			inAlg:          jwt.SigningMethodEdDSA.Alg(),
+			inPublishAhead: time.Hour,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := store.NewMemoryStore()
+
+			key, err := service.GenerateSigningKey(tt.inAlg)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			secret := []byte("keyring secret")
+
+			// replica stands for another process started with the same key.
+			replica := func() *service.Keyring {
+				keyring := service.NewKeyring(time.Minute, key)
+				assert.NoError(t, keyring.Share(db, secret, tt.inPublishAhead))
+
+				return keyring
+			}
+
+			first, second := replica(), replica()
+
+			newKey, err := first.Rotate()
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			// The keys are sealed: neither readable nor loaded without secret.
+			sealed, err := db.Get("internal:signing_keys")
+			assert.NoError(t, err)
+			assert.NotContains(t, sealed, "PRIVATE KEY")
+
+			err = service.NewKeyring(time.Minute, key).Share(db, []byte("other secret"), tt.inPublishAhead)
+			assert.ErrorIs(t, err, service.ErrKeyringSecret)
+
+			err = service.NewKeyring(time.Minute, key).Share(db, nil, tt.inPublishAhead)
+			assert.ErrorIs(t, err, service.ErrNoKeyringSecret)
+
+			// A restarted replica loads the rotated key from the store.
+			for _, keyring := range []*service.Keyring{first, replica()} {
+				if tt.inPublishAhead == 0 {
+					assert.Equal(t, newKey.KeyID, keyring.Active().KeyID)
+				} else {
+					// The new key is published before it signs.
+					assert.Equal(t, key.KeyID, keyring.Active().KeyID)
+				}
+
+				var kids []string
+				for _, k := range keyring.Keys() {
+					kids = append(kids, k.KeyID)
+				}
+
+				assert.ElementsMatch(t, []string{key.KeyID, newKey.KeyID}, kids)
+			}
+
+			if tt.inPublishAhead != 0 {
+				return
+			}
+
+			// Tokens signed by one replica verify on the other.
+			token, _, _, err := service.GetService(nil, service.WithKeyring(first)).
+				GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			_, err = service.GetService(nil, service.WithKeyring(second)).ExtractToken(token, nil)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestKeyringSlowStore(t *testing.T) {
+	t.Parallel()
+
+	db := &slowStore{
+		TokenStore: store.NewMemoryStore(),
+		reading:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	keyring := service.NewKeyring(time.Minute, key)
+	assert.NoError(t, keyring.Share(db, []byte("keyring secret"), 0))
+
+	db.slow = true
+
+	go keyring.Keys()
+
+	<-db.reading
+
+	// While one caller waits for the store, the others use the keys they have.
+	active := make(chan service.SigningKey, 1)
+
+	go func() {
+		active <- keyring.Active()
+	}()
+
+	select {
+	case k := <-active:
+		assert.Equal(t, key.KeyID, k.KeyID)
+	case <-time.After(time.Second):
+		assert.Fail(t, "Active waited for the store")
+	}
+
+	close(db.release)
+}
+
+func TestClientSecrets(t *testing.T) {
+	t.Parallel()
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodHS256.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       mock.IDTest,
+		"username": mock.UsernameTest,
+		"email":    mock.EmailTest,
+		"uuid":     uuid.NewString(),
+	}).SignedString([]byte(mock.SecretTest))
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		inOpts      []service.Option
+		inSecret    []byte
+		outKeyID    string
+		outErr      error
+		outExtracts bool
+	}{
+		{
+			name:     "ErrorClientSecret",
+			inOpts:   []service.Option{service.WithSigningKeys(key)},
+			inSecret: []byte(mock.SecretTest),
+			outErr:   service.ErrClientSecret,
+		},
+		{
+			name:     "ErrorNoKeyring",
+			inOpts:   nil,
+			inSecret: nil,
+			outErr:   service.ErrNoKeyring,
+		},
+		{
+			name:     mock.NameNoError + "ServerKey",
+			inOpts:   []service.Option{service.WithSigningKeys(key)},
+			inSecret: nil,
+			outKeyID: key.KeyID,
+			outErr:   nil,
+		},
+		{
+			name:        mock.NameNoError + "LegacySecret",
+			inOpts:      []service.Option{service.WithSigningKeys(key), service.WithClientSecrets()},
+			inSecret:    []byte(mock.SecretTest),
+			outKeyID:    "",
+			outErr:      nil,
+			outExtracts: true,
+		},
+		{
+			name:        mock.NameNoError + "LegacyServerKey",
+			inOpts:      []service.Option{service.WithSigningKeys(key), service.WithClientSecrets()},
+			inSecret:    nil,
+			outKeyID:    key.KeyID,
+			outErr:      nil,
+			outExtracts: true,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := service.GetService(nil, tt.inOpts...)
+
+			token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, tt.inSecret)
+			assert.ErrorIs(t, err, tt.outErr)
+
+			_, err = svc.ExtractToken(token, tt.inSecret)
+			if tt.outErr != nil {
+				assert.Error(t, err)
+
+				return
+			}
+
+			assert.NoError(t, err)
+
+			parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			if tt.outKeyID == "" {
+				assert.NotContains(t, parsed.Header, "kid")
+			} else {
+				assert.Equal(t, tt.outKeyID, parsed.Header["kid"])
+			}
+
+			// A legacy token signed with a client secret never verifies
+			// against server keys alone.
+			_, err = svc.ExtractToken(legacyToken, nil)
+			assert.ErrorIs(t, err, jwt.ErrSignatureInvalid)
+
+			_, err = svc.ExtractToken(legacyToken, []byte(mock.SecretTest))
+			if tt.outExtracts {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, service.ErrClientSecret)
+			}
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name   string
+		outErr error
+	}{
+		{
+			name:   mock.NameNoError,
+			outErr: nil,
+		},
+		{
+			name:   "ErrorRefreshTokenReused",
+			outErr: service.ErrRefreshTokenReused,
+		},
+		{
+			name:   "ErrorTokenType",
+			outErr: service.ErrTokenType,
+		},
+		{
+			name:   mock.NameErrorRedisClose,
+			outErr: nil,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client), service.WithSigningKeys(key))
+
+			accessToken, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			assert.NoError(t, svc.ManageToken(service.NewSetTokenState(), accessToken))
+
+			refreshToken, err := svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, accessToken)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			_, err = svc.ExtractToken(refreshToken, nil)
+			assert.ErrorIs(t, err, service.ErrTokenType)
+
+			switch tt.name {
+			case mock.NameNoError:
+				pair, err := svc.RefreshToken(refreshToken)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				claims, err := svc.ExtractToken(pair.AccessToken, nil)
+				assert.NoError(t, err)
+				assert.Equal(t, mock.IDTest, claims.ID)
+
+				check, err := svc.CheckToken(pair.AccessToken)
+				assert.NoError(t, err)
+				assert.True(t, check)
+
+				_, err = svc.RefreshToken(pair.RefreshToken)
+				assert.NoError(t, err)
+
+				// Used refresh tokens leave their family.
+				for _, key := range mr.Keys() {
+					if strings.HasPrefix(key, "internal:refresh_family:") {
+						family, _ := mr.Get(key)
+						assert.NotContains(t, family, refreshToken)
+					}
+				}
+
+			case "ErrorRefreshTokenReused":
+				pair, err := svc.RefreshToken(refreshToken)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				_, err = svc.RefreshToken(refreshToken)
+				assert.ErrorIs(t, err, tt.outErr)
+
+				// The whole family is revoked: the rotated refresh token and
+				// the access tokens issued with either refresh token.
+				_, err = svc.RefreshToken(pair.RefreshToken)
+				assert.ErrorIs(t, err, service.ErrRefreshTokenInvalid)
+
+				check, err := svc.CheckToken(pair.AccessToken)
+				assert.NoError(t, err)
+				assert.False(t, check)
+
+				check, err = svc.CheckToken(accessToken)
+				assert.NoError(t, err)
+				assert.False(t, check)
+
+			case "ErrorTokenType":
+				_, err = svc.RefreshToken(accessToken)
+				assert.ErrorIs(t, err, tt.outErr)
+
+			case mock.NameErrorRedisClose:
+				svc.DB.Close()
+
+				_, err = svc.RefreshToken(refreshToken)
+				assert.ErrorContains(t, err, mock.ErrRedisClosed)
+			}
+		})
+	}
+}
+
+func TestIntrospectToken(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name      string
+		inType    string
+		inStored  bool
+		outActive bool
+		outErr    string
+	}{
+		{
+			name:      mock.NameNoError,
+			inType:    "access",
+			inStored:  true,
+			outActive: true,
+			outErr:    "",
+		},
+		{
+			name:      mock.NameNoError + "NotStored",
+			inType:    "access",
+			inStored:  false,
+			outActive: false,
+			outErr:    "",
+		},
+		{
+			name:      mock.NameNoError + "Refresh",
+			inType:    "refresh",
+			inStored:  true,
+			outActive: true,
+			outErr:    "",
+		},
+		{
+			name:      mock.NameNoError + "NotValidToken",
+			inType:    "invalid",
+			outActive: false,
+			outErr:    "",
+		},
+		{
+			name:      mock.NameErrorRedisClose,
+			inType:    "access",
+			inStored:  true,
+			outActive: false,
+			outErr:    mock.ErrRedisClosed,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var token, resultErr string
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodES256.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client), service.WithSigningKeys(key))
+
+			switch tt.inType {
+			case "access":
+				token, _, _, err = svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+				if err == nil && tt.inStored {
+					err = svc.ManageToken(service.NewSetTokenState(), token)
+				}
+			case "refresh":
+				token, err = svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+			default:
+				token = mock.TokenTest
+			}
+
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			if tt.name == mock.NameErrorRedisClose {
+				svc.DB.Close()
+			}
+
+			claims, active, err := svc.IntrospectToken(token)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			if tt.outErr == "" {
+				assert.Empty(t, resultErr)
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+
+			assert.Equal(t, tt.outActive, active)
+
+			if tt.outActive {
+				assert.Equal(t, "1", claims.Subject)
+				assert.NotZero(t, claims.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRetry(t *testing.T) {
+	t.Parallel()
+
+	fail := false
+	db := failingStore{TokenStore: store.NewMemoryStore(), fail: &fail}
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	svc := service.GetService(db, service.WithSigningKeys(key))
+
+	refreshToken, err := svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	// Storing the new access token fails: the refresh token stays unused.
+	fail = true
+
+	_, err = svc.RefreshToken(refreshToken)
+	assert.ErrorIs(t, err, store.ErrUnavailable)
+
+	fail = false
+
+	_, active, err := svc.IntrospectToken(refreshToken)
+	assert.NoError(t, err)
+	assert.True(t, active)
+
+	pair, err := svc.RefreshToken(refreshToken)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	check, err := svc.CheckToken(pair.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, check)
+
+	sessions, err := svc.Sessions(mock.IDTest)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+}
+
+func TestDeleteTokenStateRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodES256.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	svc := service.GetService(store.NewRedisStore(client), service.WithSigningKeys(key))
+
+	refreshToken, err := svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	pair, err := svc.RefreshToken(refreshToken)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	err = svc.ManageToken(service.NewDeleteTokenState(), pair.RefreshToken)
+	assert.NoError(t, err)
+
+	_, err = svc.RefreshToken(pair.RefreshToken)
+	assert.ErrorIs(t, err, service.ErrRefreshTokenInvalid)
+
+	check, err := svc.CheckToken(pair.AccessToken)
+	assert.NoError(t, err)
+	assert.False(t, check)
+}
+
+func TestTokenPepper(t *testing.T) {
+	t.Parallel()
+
+	pepper := []byte("pepper")
+
+	for _, tt := range []struct {
+		name   string
+		outErr error
+	}{
+		{
+			name:   mock.NameNoError,
+			outErr: nil,
+		},
+		{
+			name:   "RawKeyMigration",
+			outErr: nil,
+		},
+		{
+			name:   "MigrateRawKeys",
+			outErr: nil,
+		},
+		{
+			name:   "MigrateRawKeysTenant",
+			outErr: nil,
+		},
+		{
+			name:   "ErrorNoPepper",
+			outErr: service.ErrNoPepper,
+		},
+		{
+			name:   "ErrorNoKeys",
+			outErr: store.ErrNoKeys,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			db := store.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			// legacy stores raw token keys, as before peppers were supported.
+			legacy := service.GetService(db, service.WithSigningKeys(key))
+
+			accessToken, _, _, err := legacy.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			refreshToken, err := legacy.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			switch tt.name {
+			case mock.NameNoError:
+				mr.FlushAll()
+
+				svc := service.GetService(db, service.WithSigningKeys(key), service.WithTokenPepper(pepper))
+
+				assert.NoError(t, svc.ManageToken(service.NewSetTokenState(), accessToken))
+
+				refreshToken, err = svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				pair, err := svc.RefreshToken(refreshToken)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				for _, k := range mr.Keys() {
+					assert.NotContains(t, k, ".")
+				}
+
+				check, err := svc.CheckToken(accessToken)
+				assert.NoError(t, err)
+				assert.True(t, check)
+
+				_, err = svc.RefreshToken(refreshToken)
+				assert.ErrorIs(t, err, service.ErrRefreshTokenReused)
+
+				check, err = svc.CheckToken(pair.AccessToken)
+				assert.NoError(t, err)
+				assert.False(t, check)
+
+			case "RawKeyMigration":
+				assert.NoError(t, legacy.ManageToken(service.NewSetTokenState(), accessToken))
+				mr.SetTTL(accessToken, time.Minute)
+
+				svc := service.GetService(db,
+					service.WithSigningKeys(key), service.WithTokenPepper(pepper), service.WithRawKeyMigration())
+
+				check, err := svc.CheckToken(accessToken)
+				assert.NoError(t, err)
+				assert.True(t, check)
+				assert.False(t, mr.Exists(accessToken))
+
+				check, err = svc.CheckToken(accessToken)
+				assert.NoError(t, err)
+				assert.True(t, check)
+
+				_, active, err := svc.IntrospectToken(refreshToken)
+				assert.NoError(t, err)
+				assert.True(t, active)
+
+				pair, err := svc.RefreshToken(refreshToken)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				// The family migrated with the refresh token, so revoking
+				// the rotated one still reaches the new access token.
+				assert.NoError(t, svc.ManageToken(service.NewDeleteTokenState(), pair.RefreshToken))
+
+				check, err = svc.CheckToken(pair.AccessToken)
+				assert.NoError(t, err)
+				assert.False(t, check)
+
+			case "MigrateRawKeys":
+				assert.NoError(t, legacy.ManageToken(service.NewSetTokenState(), accessToken))
+
+				// Keys of other applications sharing the store.
+				assert.NoError(t, mr.Set("config.v1", "value"))
+				assert.NoError(t, mr.Set("a.b.c", "value"))
+				mr.HSet("eyJhbGciOiJIUzI1NiJ9.e30.sig", "field", "value")
+
+				svc := service.GetService(db, service.WithSigningKeys(key), service.WithTokenPepper(pepper))
+
+				migrated, err := svc.MigrateRawKeys()
+				assert.NoError(t, err)
+				assert.Equal(t, 2, migrated)
+
+				migrated, err = svc.MigrateRawKeys()
+				assert.NoError(t, err)
+				assert.Zero(t, migrated)
+
+				assert.True(t, mr.Exists("config.v1"))
+				assert.True(t, mr.Exists("a.b.c"))
+				assert.False(t, mr.Exists(accessToken))
+
+				check, err := svc.CheckToken(accessToken)
+				assert.NoError(t, err)
+				assert.True(t, check)
+
+				_, err = svc.RefreshToken(refreshToken)
+				assert.NoError(t, err)
+
+			case "MigrateRawKeysTenant":
+				tenant := legacy.ForTenant("acme")
+
+				tenantToken, _, _, err := tenant.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				assert.NoError(t, tenant.ManageToken(service.NewSetTokenState(), tenantToken))
+
+				svc := service.GetService(db, service.WithSigningKeys(key), service.WithTokenPepper(pepper))
+
+				migrated, err := svc.MigrateRawKeys()
+				assert.NoError(t, err)
+				assert.Equal(t, 2, migrated)
+				assert.False(t, mr.Exists("tenant:acme:"+tenantToken))
+
+				check, err := svc.ForTenant("acme").CheckToken(tenantToken)
+				assert.NoError(t, err)
+				assert.True(t, check)
+
+			case "ErrorNoPepper":
+				_, err = legacy.MigrateRawKeys()
+				assert.ErrorIs(t, err, tt.outErr)
+
+			case "ErrorNoKeys":
+				svc := service.GetService(unlistedStore{TokenStore: db},
+					service.WithSigningKeys(key), service.WithTokenPepper(pepper))
+
+				migrated, err := svc.MigrateRawKeys()
+				assert.ErrorIs(t, err, tt.outErr)
+				assert.Zero(t, migrated)
+			}
+		})
+	}
+}
+
+func (unlistedStore) Keys(string) (keys []string, err error) {
+	return nil, store.ErrNoKeys
+}
+
+func (s subjectStore) Update(fn func(store.Tx) error) error {
+	return s.TokenStore.Update(func(tx store.Tx) error {
+		return fn(subjectTx{Tx: tx, subjects: s.subjects})
+	})
+}
+
+func (t subjectTx) SetSubject(key, subject string) error {
+	t.subjects[key] = subject
+
+	return nil
+}
+
+func (s failingStore) Update(fn func(store.Tx) error) error {
+	return s.TokenStore.Update(func(tx store.Tx) error {
+		return fn(failingTx{Tx: tx, fail: *s.fail})
+	})
+}
+
+func (t failingTx) Set(key, value string, ttl time.Duration) error {
+	if t.fail && !strings.Contains(key, ":") {
+		return store.ErrUnavailable
+	}
+
+	return t.Tx.Set(key, value, ttl)
+}
+
+func (s *slowStore) Get(key string) (string, error) {
+	if s.slow {
+		s.reading <- struct{}{}
+		<-s.release
+	}
+
+	return s.TokenStore.Get(key)
+}
+
+func TestTokenSubject(t *testing.T) {
+	t.Parallel()
+
+	db := subjectStore{TokenStore: store.NewMemoryStore(), subjects: map[string]string{}}
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	svc := service.GetService(db, service.WithSigningKeys(key), service.WithTokenPepper([]byte("pepper")))
+
+	accessToken, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	refreshToken, err := svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	assert.NoError(t, svc.ManageToken(service.NewSetTokenState(), accessToken))
+
+	// Both keys are hashed, yet the store knows whose they are.
+	assert.Len(t, db.subjects, 2)
+
+	for k, subject := range db.subjects {
+		assert.NotContains(t, k, accessToken)
+		assert.NotContains(t, k, refreshToken)
+		assert.Equal(t, "1", subject)
+	}
+}
+
+func TestTenant(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name     string
+		inTenant string
+		outKey   string
+		outErr   error
+	}{
+		{
+			name:     mock.NameNoError,
+			inTenant: "acme",
+			outKey:   "app:tenant:acme:",
+			outErr:   nil,
+		},
+		{
+			name:     "DefaultTenant",
+			inTenant: "",
+			outKey:   "app:",
+			outErr:   nil,
+		},
+		{
+			name:     "ErrorTenant",
+			inTenant: "acme:refresh",
+			outErr:   service.ErrTenant,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client),
+				service.WithSigningKeys(key), service.WithKeyPrefix("app:"))
+			scoped := svc.ForTenant(tt.inTenant)
+
+			token, _, _, err := scoped.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			assert.ErrorIs(t, err, tt.outErr)
+
+			if tt.outErr != nil {
+				return
+			}
+
+			assert.NoError(t, scoped.ManageToken(service.NewSetTokenState(), token))
+			assert.True(t, mr.Exists(tt.outKey+token))
+
+			claims, err := svc.ExtractToken(token, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.inTenant, claims.Tenant)
+
+			// The tenant claim picks the namespace without a tenant given.
+			check, err := svc.CheckToken(token)
+			assert.NoError(t, err)
+			assert.True(t, check)
+
+			if tt.inTenant != "" {
+				_, err = svc.ForTenant("other").CheckToken(token)
+				assert.ErrorIs(t, err, service.ErrTenantMismatch)
+
+				_, active, err := svc.ForTenant("other").IntrospectToken(token)
+				assert.NoError(t, err)
+				assert.False(t, active)
+			}
+
+			refreshToken, err := scoped.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			pair, err := svc.RefreshToken(refreshToken)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			claims, err = svc.ExtractToken(pair.AccessToken, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.inTenant, claims.Tenant)
+			assert.True(t, mr.Exists(tt.outKey+pair.AccessToken))
+
+			for _, k := range mr.Keys() {
+				assert.True(t, strings.HasPrefix(k, tt.outKey), k)
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	svc := service.GetService(store.NewRedisStore(client), service.WithSigningKeys(key)).
+		ForClient(service.Client{UserAgent: "curl/8.0", IP: "192.0.2.1"})
+
+	var tokens []string
+
+	for _, id := range []int{mock.IDTest, mock.IDTest, mock.IDTest + 1} {
+		token, _, _, err := svc.GenerateToken(id, mock.UsernameTest, mock.EmailTest, nil)
+		if err != nil {
+			assert.Fail(t, err.Error())
+		}
+
+		assert.NoError(t, svc.ManageToken(service.NewSetTokenState(), token))
+
+		tokens = append(tokens, token)
+	}
+
+	refreshToken, err := svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	// A token the service did not sign is whitelisted, but not listed.
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"id":       mock.IDTest,
+		"username": mock.UsernameTest,
+		"email":    mock.EmailTest,
+		"uuid":     uuid.NewString(),
+	}).SignedString([]byte("attacker"))
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	assert.NoError(t, svc.ManageToken(service.NewSetTokenState(), forged))
+
+	sessions, err := svc.Sessions(mock.IDTest)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 3)
+
+	for _, session := range sessions {
+		assert.Equal(t, mock.IDTest, session.UserID)
+		assert.Equal(t, "curl/8.0", session.UserAgent)
+		assert.Equal(t, "192.0.2.1", session.IP)
+		assert.NotEmpty(t, session.ID)
+	}
+
+	// Revoked tokens leave the index.
+	assert.NoError(t, svc.ManageToken(service.NewDeleteTokenState(), tokens[0]))
+
+	sessions, err = svc.Sessions(mock.IDTest)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	revoked, err := svc.RevokeSessions(mock.IDTest)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, revoked)
+
+	check, err := svc.CheckToken(tokens[1])
+	assert.NoError(t, err)
+	assert.False(t, check)
+
+	_, err = svc.RefreshToken(refreshToken)
+	assert.ErrorIs(t, err, service.ErrRefreshTokenInvalid)
+
+	sessions, err = svc.Sessions(mock.IDTest)
+	assert.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	// Other users keep their sessions.
+	check, err = svc.CheckToken(tokens[2])
+	assert.NoError(t, err)
+	assert.True(t, check)
+
+	sessions, err = svc.Sessions(mock.IDTest + 1)
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 1)
+}
+
+func TestMaxSessions(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name        string
+		inPolicy    service.SessionLimitPolicy
+		outErr      error
+		outEvicted  int
+		outSessions int
+	}{
+		{
+			name:        "Reject",
+			inPolicy:    service.SessionLimitReject,
+			outErr:      service.ErrTooManySessions,
+			outEvicted:  0,
+			outSessions: 2,
+		},
+		{
+			name:        "EvictOldest",
+			inPolicy:    service.SessionLimitEvictOldest,
+			outErr:      nil,
+			outEvicted:  1,
+			outSessions: 2,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client),
+				service.WithSigningKeys(key), service.WithMaxSessions(2, tt.inPolicy))
+
+			tokens := make([]string, 3)
+
+			for i := range tokens {
+				tokens[i], _, _, err = svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+			}
+
+			for _, token := range tokens[:2] {
+				evicted, err := svc.StoreToken(token, 0)
+				assert.NoError(t, err)
+				assert.Empty(t, evicted)
+			}
+
+			// Storing a token again does not take another session.
+			_, err = svc.StoreToken(tokens[1], 0)
+			assert.NoError(t, err)
+
+			// A token the service did not sign is refused rather than counted
+			// against the sessions of the user it claims.
+			forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				"id":       mock.IDTest,
+				"username": mock.UsernameTest,
+				"email":    mock.EmailTest,
+				"uuid":     uuid.NewString(),
+			}).SignedString([]byte("attacker"))
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			_, err = svc.StoreToken(forged, 0)
+			assert.ErrorIs(t, err, service.ErrNoSecret)
+
+			check, err := svc.CheckToken(forged)
+			assert.NoError(t, err)
+			assert.False(t, check)
+
+			evicted, err := svc.StoreToken(tokens[2], 0)
+			assert.ErrorIs(t, err, tt.outErr)
+			assert.Len(t, evicted, tt.outEvicted)
+
+			check, err = svc.CheckToken(tokens[0])
+			assert.NoError(t, err)
+			assert.Equal(t, tt.outEvicted == 0, check)
+
+			check, err = svc.CheckToken(tokens[2])
+			assert.NoError(t, err)
+			assert.Equal(t, tt.outErr == nil, check)
+
+			sessions, err := svc.Sessions(mock.IDTest)
+			assert.NoError(t, err)
+			assert.Len(t, sessions, tt.outSessions)
+
+			// A refresh exchange evicts even when new logins are rejected.
+			refreshToken, err := svc.GenerateRefreshToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, "")
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			_, err = svc.RefreshToken(refreshToken)
+			assert.NoError(t, err)
+
+			sessions, err = svc.Sessions(mock.IDTest)
+			assert.NoError(t, err)
+			assert.Len(t, sessions, 3)
+		})
+	}
+}
+
+func TestMaxSessionsConcurrency(t *testing.T) {
+	t.Parallel()
+
+	// miniredis v1 stalls on concurrent WATCH transactions.
+	mr := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Error(t, err)
+	}
+
+	svc := service.GetService(store.NewRedisStore(client),
+		service.WithSigningKeys(key), service.WithMaxSessions(2, service.SessionLimitReject))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+		if err != nil {
+			assert.Fail(t, err.Error())
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, _ = svc.StoreToken(token, 0)
+		}()
+	}
+
+	wg.Wait()
+
+	sessions, err := svc.Sessions(mock.IDTest)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, len(sessions), 2)
+
+	stored := 0
+
+	for _, k := range mr.Keys() {
+		if strings.Count(k, ".") == 2 {
+			stored++
+		}
+	}
+
+	assert.Equal(t, len(sessions), stored)
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name       string
+		inValue    string
+		inAbsolute time.Duration
+		outTTL     time.Duration
+		outCheck   bool
+	}{
+		{
+			name:       mock.NameNoError,
+			inAbsolute: time.Hour,
+			outTTL:     time.Minute,
+			outCheck:   true,
+		},
+		{
+			name:       "AbsoluteTimeout",
+			inAbsolute: 30 * time.Second,
+			outTTL:     30 * time.Second,
+			outCheck:   true,
+		},
+		{
+			name:       "DeadlinePassed",
+			inValue:    "until:1",
+			inAbsolute: time.Hour,
+			outTTL:     0,
+			outCheck:   false,
+		},
+		{
+			name:       "NoDeadline",
+			inValue:    "1",
+			inAbsolute: time.Hour,
+			outTTL:     70 * time.Second,
+			outCheck:   true,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client),
+				service.WithSigningKeys(key), service.WithSlidingExpiration(time.Minute, tt.inAbsolute))
+
+			token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			assert.NoError(t, svc.ManageToken(service.NewSetTokenState(), token))
+
+			if tt.inValue != "" {
+				mr.Set(token, tt.inValue)
+				mr.SetTTL(token, 2*time.Minute)
+			}
+
+			mr.FastForward(50 * time.Second)
+
+			// Idle for less than the timeout, so the check extends the TTL.
+			check, err := svc.CheckToken(token)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.outCheck, check)
+			assert.InDelta(t, tt.outTTL, mr.TTL(token), float64(2*time.Second))
+
+			if !tt.outCheck {
+				assert.False(t, mr.Exists(token))
+
+				return
+			}
+
+			mr.FastForward(tt.outTTL)
+
+			check, err = svc.CheckToken(token)
+			assert.NoError(t, err)
+			assert.False(t, check)
+		})
+	}
+}
+
+func TestStoreTokenTTL(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name       string
+		inToken    string
+		inLifetime time.Duration
+		inTTL      time.Duration
+		inMin      time.Duration
+		inMax      time.Duration
+		outTTL     time.Duration
+		outErr     error
+	}{
+		{
+			name:       "FromExp",
+			inLifetime: 30 * time.Minute,
+			outTTL:     30 * time.Minute,
+		},
+		{
+			name:       "Requested",
+			inLifetime: 30 * time.Minute,
+			inTTL:      time.Minute,
+			outTTL:     time.Minute,
+		},
+		{
+			name:       "RequestedPastExp",
+			inLifetime: 30 * time.Minute,
+			inTTL:      2 * time.Hour,
+			outTTL:     30 * time.Minute,
+		},
+		{
+			name:       "Min",
+			inLifetime: 30 * time.Minute,
+			inTTL:      time.Second,
+			inMin:      10 * time.Second,
+			outTTL:     10 * time.Second,
+		},
+		{
+			name:       "MinPastExp",
+			inLifetime: 5 * time.Second,
+			inMin:      time.Hour,
+			outTTL:     5 * time.Second,
+		},
+		{
+			name:       "Max",
+			inLifetime: 30 * time.Minute,
+			inMax:      5 * time.Minute,
+			outTTL:     5 * time.Minute,
+		},
+		{
+			name:    "NoExp",
+			inToken: mock.TokenTest,
+			outTTL:  10 * time.Minute,
+		},
+		{
+			name:       "ErrorTTL",
+			inLifetime: 30 * time.Minute,
+			inTTL:      -time.Second,
+			outErr:     service.ErrTTL,
+		},
+		{
+			name:       "ErrorTokenExpired",
+			inLifetime: -time.Minute,
+			outErr:     service.ErrTokenExpired,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mr, err := miniredis.Run()
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+			key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+			if err != nil {
+				assert.Error(t, err)
+			}
+
+			svc := service.GetService(store.NewRedisStore(client),
+				service.WithSigningKeys(key),
+				service.WithTokenLifetime(tt.inLifetime),
+				service.WithTokenTTLBounds(tt.inMin, tt.inMax),
+			)
+
+			token := tt.inToken
+			if token == "" {
+				if token, _, _, err = svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil); err != nil {
+					assert.Fail(t, err.Error())
+				}
+			}
+
+			_, err = svc.StoreToken(token, tt.inTTL)
+			assert.ErrorIs(t, err, tt.outErr)
+
+			if tt.outErr != nil {
+				assert.False(t, mr.Exists(token))
+
+				return
+			}
+
+			assert.InDelta(t, tt.outTTL, mr.TTL(token), float64(2*time.Second))
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache/internal/store"
+)
+
+// Session is a token listed in the index of its user.
+type Session struct {
+	ID        string `json:"id"`
+	Key       string `json:"key"`
+	Type      string `json:"type"`
+	UserAgent string `json:"user_agent,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserID    int    `json:"user_id"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Client describes where a request comes from.
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// SessionLimitPolicy decides what storing a token over WithMaxSessions does.
+type SessionLimitPolicy string
+
+type clientContextKey struct{}
+
+// sessionLimit caps the access tokens of a user.
+type sessionLimit struct {
+	policy SessionLimitPolicy
+	max    int
+}
+
+// Session limit policies.
+const (
+	SessionLimitReject      SessionLimitPolicy = "reject"
+	SessionLimitEvictOldest SessionLimitPolicy = "evict_oldest"
+)
+
+const (
+	accessTokenType    = "access"
+	userSessionsPrefix = internalKeyPrefix + "user_sessions:"
+)
+
+var ErrTooManySessions = errors.New("too many active sessions")
+
+// WithMaxSessions caps the access tokens a user can hold at once. Storing one
+// more either fails with ErrTooManySessions or evicts the oldest, as policy
+// says. Refresh exchanges always evict: they continue an existing session.
+// Tokens that do not verify with the server-held keys are refused.
+func WithMaxSessions(max int, policy SessionLimitPolicy) Option {
+	return func(s *service) {
+		s.sessionLimit = sessionLimit{max: max, policy: policy}
+	}
+}
+
+// ContextWithClient returns a copy of ctx carrying the client of the request.
+func ContextWithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+// ClientFromContext returns the client set by ContextWithClient, if any.
+func ClientFromContext(ctx context.Context) (client Client) {
+	client, _ = ctx.Value(clientContextKey{}).(Client)
+
+	return client
+}
+
+// ForClient returns a copy of the service that records client in the
+// sessions of the tokens it stores.
+func (s *service) ForClient(client Client) Service {
+	scoped := *s
+	scoped.client = client
+
+	return &scoped
+}
+
+// StoreToken whitelists token like ManageToken with a SetTokenState, for ttl
+// if not 0, and returns the sessions evicted to respect WithMaxSessions.
+func (s *service) StoreToken(token string, ttl time.Duration) (evicted []Session, err error) {
+	st := NewSetTokenState()
+	st.evicted = &evicted
+	st.ttl = ttl
+
+	if err = s.ManageToken(st, token); err != nil {
+		return nil, err
+	}
+
+	return evicted, nil
+}
+
+// Sessions lists the active access and refresh tokens of the user.
+func (s *service) Sessions(userID int) (sessions []Session, err error) {
+	err = s.namespace(s.tenant).Update(func(tx store.Tx) error {
+		var readErr error
+
+		sessions, readErr = liveSessions(tx, userSessionsKey(userID))
+
+		return readErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSessions atomically revokes every token of the user, along with the
+// families of its refresh tokens, and returns how many there were.
+func (s *service) RevokeSessions(userID int) (revoked int, err error) {
+	err = s.namespace(s.tenant).Update(func(tx store.Tx) error {
+		key := userSessionsKey(userID)
+
+		sessions, readErr := liveSessions(tx, key)
+		if readErr != nil {
+			return readErr
+		}
+
+		for _, session := range sessions {
+			if revokeErr := revokeSession(tx, session); revokeErr != nil {
+				return revokeErr
+			}
+		}
+
+		revoked = len(sessions)
+
+		return tx.Delete(key)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error to revoke sessions: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// session describes token for the index of its user. It fails unless token
+// verifies with the server-held keys, so that nobody lists a token under a
+// user it was not issued for.
+func (s service) session(token, tokenType string) (session *Session, err error) {
+	claims, err := s.parseToken(token, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		ID:        claims.TokenID,
+		Type:      tokenType,
+		UserAgent: s.client.UserAgent,
+		IP:        s.client.IP,
+		UserID:    claims.ID,
+		IssuedAt:  claims.IssuedAt,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+func userSessionsKey(userID int) (key string) {
+	return userSessionsPrefix + strconv.Itoa(userID)
+}
+
+// addSessions adds sessions, all of one user, to the index of the user,
+// dropping the sessions that are not active anymore and enforcing limit on
+// access tokens. They are added at once because a transaction cannot read its
+// own writes.
+func addSessions(tx store.Tx, limit sessionLimit, added ...Session) (evicted []Session, err error) {
+	var adding int
+
+	for _, session := range added {
+		// The key may be hashed; tell the store who it belongs to for auditing.
+		if err = store.SetSubject(tx, session.Key, strconv.Itoa(session.UserID)); err != nil {
+			return nil, err
+		}
+
+		if session.Type == accessTokenType {
+			adding++
+		}
+	}
+
+	key := userSessionsKey(added[0].UserID)
+
+	live, err := liveSessions(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sessions []Session
+		access   []Session
+	)
+
+	for _, other := range live {
+		switch {
+		case hasSession(added, other.Key):
+		case other.Type == accessTokenType:
+			access = append(access, other)
+		default:
+			sessions = append(sessions, other)
+		}
+	}
+
+	if over := len(access) + adding - limit.max; limit.max > 0 && adding > 0 && over > 0 {
+		if limit.policy != SessionLimitEvictOldest {
+			return nil, fmt.Errorf("%w: %d allowed", ErrTooManySessions, limit.max)
+		}
+
+		sort.SliceStable(access, func(i, j int) bool {
+			return access[i].IssuedAt < access[j].IssuedAt
+		})
+
+		evicted, access = access[:over], access[over:]
+
+		for _, other := range evicted {
+			if err = tx.Delete(other.Key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return evicted, setSessions(tx, key, append(append(sessions, access...), added...))
+}
+
+func hasSession(sessions []Session, key string) (found bool) {
+	for _, session := range sessions {
+		if session.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func setSessions(tx store.Tx, key string, sessions []Session) (err error) {
+	value, err := json.Marshal(sessions)
+	if err != nil {
+		return fmt.Errorf("error to encode sessions: %w", err)
+	}
+
+	// The index lives as long as the longest lived session.
+	var ttl time.Duration
+
+	for _, session := range sessions {
+		if session.ExpiresAt == 0 {
+			ttl = 0
+
+			break
+		}
+
+		if remaining := time.Until(time.Unix(session.ExpiresAt, 0)); remaining > ttl {
+			ttl = remaining
+		}
+	}
+
+	return tx.Set(key, string(value), ttl)
+}
+
+// liveSessions returns the sessions indexed under key whose token is still
+// stored and, for refresh tokens, unused.
+func liveSessions(tx store.Tx, key string) (sessions []Session, err error) {
+	value, err := tx.Get(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var indexed []Session
+	if err = json.Unmarshal([]byte(value), &indexed); err != nil {
+		return nil, fmt.Errorf("error to decode sessions: %w", err)
+	}
+
+	for _, session := range indexed {
+		value, err = tx.Get(session.Key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if session.Type == refreshTokenType && !strings.HasPrefix(value, refreshActive+":") {
+			continue
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+func revokeSession(tx store.Tx, session Session) (err error) {
+	if session.Type == refreshTokenType {
+		return revokeRefreshToken(tx, session.Key)
+	}
+
+	return tx.Delete(session.Key)
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"cache/internal/store"
+)
+
+// untilPrefix marks the value of a token stored with an absolute deadline.
+const untilPrefix = "until:"
+
+// WithSlidingExpiration keeps a token whitelisted for idle after each
+// successful CheckToken rather than for its whole TTL, but never longer than
+// absolute after it was stored nor past its TTL. absolute 0 only bounds it by
+// the TTL.
+func WithSlidingExpiration(idle, absolute time.Duration) Option {
+	return func(s *service) {
+		s.idleTimeout = idle
+		s.absoluteTimeout = absolute
+	}
+}
+
+// deadline returns when a token whitelisted for ttl stops being valid
+// however often it is checked.
+func (s service) deadline(ttl time.Duration) (deadline time.Time) {
+	if s.absoluteTimeout > 0 && s.absoluteTimeout < ttl {
+		ttl = s.absoluteTimeout
+	}
+
+	return time.Now().Add(ttl)
+}
+
+// slide reports whether key is stored and extends its TTL to the idle
+// timeout, bounded by its deadline. Tokens stored without a deadline keep
+// their TTL.
+func (s service) slide(db store.TokenStore, key string) (check bool, err error) {
+	err = db.Update(func(tx store.Tx) error {
+		check = false
+
+		value, getErr := tx.Get(key)
+		if errors.Is(getErr, store.ErrNotFound) {
+			return nil
+		}
+
+		if getErr != nil {
+			return getErr
+		}
+
+		check = whitelisted(value)
+
+		if !check || !strings.HasPrefix(value, untilPrefix) {
+			return nil
+		}
+
+		ttl := s.idleTimeout
+
+		if deadline, parseErr := strconv.ParseInt(strings.TrimPrefix(value, untilPrefix), 10, 64); parseErr == nil && deadline != 0 {
+			remaining := time.Until(time.Unix(deadline, 0))
+			if remaining <= 0 {
+				check = false
+
+				return tx.Delete(key)
+			}
+
+			if remaining < ttl {
+				ttl = remaining
+			}
+		}
+
+		return tx.Set(key, value, ttl)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return check, nil
+}
@@ -1,27 +1,86 @@
 package service
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/go-redis/redis"
+	"cache/internal/store"
 )
 
 type State interface {
-	ManageToken(*redis.Client, string) error
+	ManageToken(store.TokenStore, string) error
 }
 
 type (
-	SetTokenState    struct{}
+	// SetTokenState whitelists a token and, when it carries a user id, lists
+	// it in the sessions of the user.
+	SetTokenState struct {
+		session  *Session
+		evicted  *[]Session
+		deadline time.Time
+		limit    sessionLimit
+		ttl      time.Duration
+	}
+
 	DeleteTokenState struct{}
 )
 
+type (
+	// SetRefreshTokenState stores a refresh token as active in its family.
+	SetRefreshTokenState struct {
+		session     *Session
+		family      string
+		accessToken string
+		lifetime    time.Duration
+	}
+
+	// UseRefreshTokenState marks a refresh token as used. Presenting a used
+	// token again deletes every token of its family.
+	UseRefreshTokenState struct{}
+)
+
+const (
+	whitelistedValue = "1"
+
+	refreshTokenPrefix  = internalKeyPrefix + "refresh:"
+	refreshFamilyPrefix = internalKeyPrefix + "refresh_family:"
+	refreshActive       = "active"
+	refreshUsed         = "used"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, token family revoked")
+)
+
 func NewSetTokenState() SetTokenState {
 	return SetTokenState{}
 }
 
-func (SetTokenState) ManageToken(db *redis.Client, token string) (err error) {
-	err = db.Set(token, true, time.Minute*time.Duration(lifeOfToken)).Err()
+// ManageToken stores the token for the TTL the service picked, with its
+// absolute deadline when sliding expiration is enabled.
+func (st SetTokenState) ManageToken(db store.TokenStore, token string) (err error) {
+	if st.session == nil {
+		value, ttl := st.entry()
+		err = db.Set(token, value, ttl)
+	} else {
+		err = db.Update(func(tx store.Tx) error {
+			if setErr := st.set(tx, token); setErr != nil {
+				return setErr
+			}
+
+			evicted, addErr := addSessions(tx, st.limit, st.sessionOf(token))
+			if addErr == nil && st.evicted != nil {
+				*st.evicted = evicted
+			}
+
+			return addErr
+		})
+	}
+
 	if err != nil {
 		return fmt.Errorf("error to set token: %w", err)
 	}
@@ -29,14 +88,238 @@ func (SetTokenState) ManageToken(db *redis.Client, token string) (err error) {
 	return nil
 }
 
+// entry returns the value and TTL the token is stored with.
+func (st SetTokenState) entry() (value string, ttl time.Duration) {
+	ttl = st.ttl
+	if ttl == 0 {
+		ttl = time.Minute * time.Duration(lifeOfToken)
+	}
+
+	value = whitelistedValue
+	if !st.deadline.IsZero() {
+		value = untilPrefix + strconv.FormatInt(st.deadline.Unix(), 10)
+	}
+
+	return value, ttl
+}
+
+// set stores the token in tx, without indexing its session.
+func (st SetTokenState) set(tx store.Tx, token string) (err error) {
+	value, ttl := st.entry()
+
+	return tx.Set(token, value, ttl)
+}
+
+func (st SetTokenState) sessionOf(token string) (session Session) {
+	session = *st.session
+	session.Key = token
+
+	return session
+}
+
+// whitelisted reports whether value is one SetTokenState stores tokens with.
+func whitelisted(value string) (ok bool) {
+	return value == whitelistedValue || strings.HasPrefix(value, untilPrefix)
+}
+
 func NewDeleteTokenState() DeleteTokenState {
 	return DeleteTokenState{}
 }
 
-func (DeleteTokenState) ManageToken(db *redis.Client, token string) (err error) {
-	if err = db.Del(token).Err(); err != nil {
+// ManageToken deletes the token. For a refresh token it revokes its whole
+// family, including the access tokens issued with it.
+func (DeleteTokenState) ManageToken(db store.TokenStore, token string) (err error) {
+	if err = db.Delete(token); err != nil {
 		return fmt.Errorf("failed to delete token: %w", err)
 	}
 
+	err = db.Update(func(tx store.Tx) error {
+		return revokeRefreshToken(tx, refreshTokenPrefix+token)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete refresh token: %w", err)
+	}
+
 	return nil
 }
+
+// NewSetRefreshTokenState registers the refresh token in family. accessToken,
+// if given, is the key of the access token issued with it, deleted along with
+// the family when reuse is detected.
+func NewSetRefreshTokenState(family, accessToken string, lifetime time.Duration) SetRefreshTokenState {
+	return SetRefreshTokenState{family: family, accessToken: accessToken, lifetime: lifetime}
+}
+
+func (st SetRefreshTokenState) ManageToken(db store.TokenStore, token string) (err error) {
+	err = db.Update(func(tx store.Tx) error {
+		return st.add(tx, token)
+	})
+	if err != nil {
+		return fmt.Errorf("error to set refresh token: %w", err)
+	}
+
+	return nil
+}
+
+func (st SetRefreshTokenState) add(tx store.Tx, token string) (err error) {
+	if err = st.addToFamily(tx, token); err != nil {
+		return err
+	}
+
+	if st.session == nil {
+		return nil
+	}
+
+	_, err = addSessions(tx, sessionLimit{}, st.sessionOf(token))
+
+	return err
+}
+
+// addToFamily stores the refresh token as active in its family, without
+// indexing its session. The members already used or expired are dropped, so
+// the family does not grow with every exchange.
+func (st SetRefreshTokenState) addToFamily(tx store.Tx, token string) (err error) {
+	familyKey := refreshFamilyPrefix + st.family
+
+	members, err := familyMembers(tx, familyKey)
+	if err != nil {
+		return err
+	}
+
+	if members, err = liveMembers(tx, members); err != nil {
+		return err
+	}
+
+	members = append(members, refreshTokenPrefix+token)
+	if st.accessToken != "" {
+		members = append(members, st.accessToken)
+	}
+
+	if err = tx.Set(refreshTokenPrefix+token, refreshActive+":"+st.family, st.lifetime); err != nil {
+		return err
+	}
+
+	return tx.Set(familyKey, strings.Join(members, "\n"), st.lifetime)
+}
+
+func (st SetRefreshTokenState) sessionOf(token string) (session Session) {
+	session = *st.session
+	session.Key = refreshTokenPrefix + token
+
+	return session
+}
+
+func NewUseRefreshTokenState() UseRefreshTokenState {
+	return UseRefreshTokenState{}
+}
+
+func (UseRefreshTokenState) ManageToken(db store.TokenStore, token string) (err error) {
+	var reused bool
+
+	err = db.Update(func(tx store.Tx) error {
+		reused = false
+
+		return useRefreshToken(tx, refreshTokenPrefix+token, &reused)
+	})
+	if err == nil && reused {
+		err = ErrRefreshTokenReused
+	}
+
+	if err != nil {
+		return fmt.Errorf("error to use refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// useRefreshToken marks key as used. When it already was, it revokes the
+// family and sets reused instead, so that the revocation is committed.
+func useRefreshToken(tx store.Tx, key string, reused *bool) (err error) {
+	value, err := tx.Get(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrRefreshTokenInvalid
+	}
+
+	if err != nil {
+		return err
+	}
+
+	status, family, _ := strings.Cut(value, ":")
+
+	if status == refreshUsed {
+		*reused = true
+
+		return revokeFamily(tx, family, key)
+	}
+
+	ttl, err := tx.TTL(key)
+	if err != nil {
+		return err
+	}
+
+	return tx.Set(key, refreshUsed+":"+family, ttl)
+}
+
+func revokeRefreshToken(tx store.Tx, key string) (err error) {
+	value, err := tx.Get(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, family, _ := strings.Cut(value, ":")
+
+	return revokeFamily(tx, family, key)
+}
+
+// revokeFamily deletes every member of the refresh token family and keys.
+func revokeFamily(tx store.Tx, family string, keys ...string) (err error) {
+	familyKey := refreshFamilyPrefix + family
+
+	members, err := familyMembers(tx, familyKey)
+	if err != nil {
+		return err
+	}
+
+	return tx.Delete(append(append(members, familyKey), keys...)...)
+}
+
+// familyMembers returns the keys stored in the family, one per line.
+func familyMembers(tx store.Tx, familyKey string) (members []string, err error) {
+	value, err := tx.Get(familyKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(value, "\n"), nil
+}
+
+// liveMembers returns the members still worth revoking: the access tokens
+// still stored and the refresh tokens still unused.
+func liveMembers(tx store.Tx, members []string) (live []string, err error) {
+	for _, member := range members {
+		value, getErr := tx.Get(member)
+		if errors.Is(getErr, store.ErrNotFound) {
+			continue
+		}
+
+		if getErr != nil {
+			return nil, getErr
+		}
+
+		if strings.HasPrefix(member, refreshTokenPrefix) && !strings.HasPrefix(value, refreshActive+":") {
+			continue
+		}
+
+		live = append(live, member)
+	}
+
+	return live, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cache/internal/store"
+
+	"github.com/golang-jwt/jwt"
+)
+
+type tenantContextKey struct{}
+
+const (
+	tenantClaim     = "tenant"
+	tenantKeyPrefix = "tenant:"
+)
+
+var (
+	ErrTenant         = errors.New("invalid tenant")
+	ErrTenantMismatch = errors.New("token belongs to another tenant")
+
+	tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// WithKeyPrefix prefixes every key the service stores, so the store can be
+// shared with other applications.
+func WithKeyPrefix(prefix string) Option {
+	return func(s *service) {
+		s.keyPrefix = prefix
+	}
+}
+
+// ContextWithTenant returns a copy of ctx carrying the tenant of the request.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by ContextWithTenant, if any.
+func TenantFromContext(ctx context.Context) (tenant string) {
+	tenant, _ = ctx.Value(tenantContextKey{}).(string)
+
+	return tenant
+}
+
+// ForTenant returns a copy of the service working for tenant. The tokens it
+// generates carry a tenant claim, and tokens without one are stored in the
+// tenant namespace. A token claiming another tenant is rejected with
+// ErrTenantMismatch.
+func (s *service) ForTenant(tenant string) Service {
+	scoped := *s
+	scoped.tenant = tenant
+
+	return &scoped
+}
+
+// db returns the namespace of the store token lives in: the one of its tenant
+// claim, else the one of the service tenant, else the default one. A token
+// that would be stored among the internal records fails with ErrReservedKey.
+func (s service) db(token string) (db store.TokenStore, err error) {
+	if strings.HasPrefix(token, internalKeyPrefix) {
+		return nil, ErrReservedKey
+	}
+
+	tenant := s.tenant
+
+	if claimed := unverifiedTenant(token); claimed != "" {
+		if tenant != "" && tenant != claimed {
+			return nil, ErrTenantMismatch
+		}
+
+		tenant = claimed
+	}
+
+	if err = checkTenant(tenant); err != nil {
+		return nil, err
+	}
+
+	return s.namespace(tenant), nil
+}
+
+func (s service) namespace(tenant string) (db store.TokenStore) {
+	prefix := s.keyPrefix
+	if tenant != "" {
+		prefix += tenantKeyPrefix + tenant + ":"
+	}
+
+	if prefix == "" {
+		return s.DB
+	}
+
+	return store.NewNamespace(s.DB, prefix)
+}
+
+// splitTenant splits a key listed in the default namespace into the tenant
+// whose namespace holds it and the key within that namespace.
+func splitTenant(key string) (tenant, rest string) {
+	tenant, rest, ok := strings.Cut(strings.TrimPrefix(key, tenantKeyPrefix), ":")
+	if !ok || !strings.HasPrefix(key, tenantKeyPrefix) || !tenantPattern.MatchString(tenant) {
+		return "", key
+	}
+
+	return tenant, rest
+}
+
+// checkTenant rejects tenants that could escape their namespace.
+func checkTenant(tenant string) (err error) {
+	if tenant != "" && !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("%w: %q", ErrTenant, tenant)
+	}
+
+	return nil
+}
+
+// withTenant adds the tenant claim of the service to mapClaims.
+func (s service) withTenant(mapClaims jwt.MapClaims) jwt.MapClaims {
+	if s.tenant != "" {
+		mapClaims[tenantClaim] = s.tenant
+	}
+
+	return mapClaims
+}
+
+// unverifiedTenant reads the tenant claim without verifying token: it only
+// picks the namespace, where a forged token is not stored.
+func unverifiedTenant(token string) (tenant string) {
+	t, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+
+	mapClaims, _ := t.Claims.(jwt.MapClaims)
+	tenant, _ = mapClaims[tenantClaim].(string)
+
+	return tenant
+}
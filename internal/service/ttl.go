@@ -0,0 +1,64 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrTTL = errors.New("invalid ttl")
+
+// WithTokenTTLBounds clamps the TTL tokens are whitelisted for, whether given
+// with the token or derived from its exp claim. 0 leaves a bound open. A token
+// with less than min left is whitelisted until its exp claim only.
+func WithTokenTTLBounds(min, max time.Duration) Option {
+	return func(s *service) {
+		s.minTokenTTL = min
+		s.maxTokenTTL = max
+	}
+}
+
+// tokenTTL returns how long to whitelist the token of session for: requested
+// if given, else until its exp claim, else the default lifetime; then clamped
+// to the configured bounds. It never outlives the exp claim, not even to honour
+// the lower bound, so that a token stops checking as soon as it expires and
+// its session index entry, which expires with it, can still find it.
+func (s service) tokenTTL(session *Session, requested time.Duration) (ttl time.Duration, err error) {
+	if requested < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrTTL, requested)
+	}
+
+	ttl = requested
+
+	var remaining time.Duration
+
+	if session != nil && session.ExpiresAt != 0 {
+		exp := time.Unix(session.ExpiresAt, 0)
+
+		if remaining = time.Until(exp); remaining <= 0 {
+			return 0, fmt.Errorf("%w: expired at %s", ErrTokenExpired, exp.UTC().Format(time.RFC3339))
+		}
+
+		if ttl == 0 {
+			ttl = remaining
+		}
+	}
+
+	if ttl == 0 {
+		ttl = time.Minute * time.Duration(lifeOfToken)
+	}
+
+	if s.minTokenTTL > 0 && ttl < s.minTokenTTL {
+		ttl = s.minTokenTTL
+	}
+
+	if s.maxTokenTTL > 0 && ttl > s.maxTokenTTL {
+		ttl = s.maxTokenTTL
+	}
+
+	if remaining > 0 && ttl > remaining {
+		ttl = remaining
+	}
+
+	return ttl, nil
+}
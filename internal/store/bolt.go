@@ -0,0 +1,249 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStore is a TokenStore kept in a bbolt file, so tokens survive restarts.
+// Expired keys are never returned and are removed by Compact, which the
+// janitor runs periodically.
+type BoltStore struct {
+	db   *bolt.DB
+	now  func() time.Time
+	done chan struct{}
+}
+
+// boltTx writes straight to the bucket; bbolt discards them if fn fails.
+type boltTx struct {
+	bucket *bolt.Bucket
+	now    time.Time
+}
+
+const (
+	boltFileMode   = 0o600
+	boltTimeout    = time.Second
+	expiresAtBytes = 8
+)
+
+var tokensBucket = []byte("tokens")
+
+// NewBoltStore opens or creates the bbolt file at path and starts the janitor
+// unless WithJanitorInterval(0) is given; Close stops it.
+func NewBoltStore(path string, opts ...Option) (b *BoltStore, err error) {
+	o := newOptions(opts)
+
+	db, err := bolt.Open(path, boltFileMode, &bolt.Options{Timeout: boltTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error to open bolt store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, bucketErr := tx.CreateBucketIfNotExists(tokensBucket)
+
+		return bucketErr
+	})
+	if err != nil {
+		db.Close()
+
+		return nil, fmt.Errorf("error to create bolt bucket: %w", err)
+	}
+
+	b = &BoltStore{db: db, now: o.now, done: make(chan struct{})}
+
+	if o.janitorInterval > 0 {
+		go janitor(o.janitorInterval, b.done, func() {
+			_, _ = b.Compact()
+		})
+	}
+
+	return b, nil
+}
+
+// Set ...
+func (b *BoltStore) Set(key, value string, ttl time.Duration) (err error) {
+	return b.Update(func(tx Tx) error {
+		return tx.Set(key, value, ttl)
+	})
+}
+
+// Get ...
+func (b *BoltStore) Get(key string) (value string, err error) {
+	err = b.db.View(func(tx *bolt.Tx) error {
+		var getErr error
+
+		value, getErr = b.tx(tx).Get(key)
+
+		return getErr
+	})
+
+	return value, err
+}
+
+// Delete ...
+func (b *BoltStore) Delete(keys ...string) (err error) {
+	return b.Update(func(tx Tx) error {
+		return tx.Delete(keys...)
+	})
+}
+
+// Exists ...
+func (b *BoltStore) Exists(key string) (exists bool, err error) {
+	_, err = b.Get(key)
+
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Keys ...
+func (b *BoltStore) Keys(prefix string) (keys []string, err error) {
+	now := b.now()
+
+	err = b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(tokensBucket).Cursor()
+
+		for k, v := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, v = c.Next() {
+			if !expired(v, now) {
+				keys = append(keys, string(k))
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error to list bolt keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Update runs fn in a bbolt read-write transaction, which excludes any other.
+func (b *BoltStore) Update(fn func(Tx) error) (err error) {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return fn(b.tx(tx))
+	})
+}
+
+// Compact deletes every expired key and returns how many there were.
+func (b *BoltStore) Compact() (removed int, err error) {
+	now := b.now()
+
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tokensBucket)
+
+		// Deleting while iterating makes the cursor skip keys.
+		var keys [][]byte
+
+		_ = bucket.ForEach(func(k, v []byte) error {
+			if expired(v, now) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+
+		for _, k := range keys {
+			if deleteErr := bucket.Delete(k); deleteErr != nil {
+				return deleteErr
+			}
+		}
+
+		removed = len(keys)
+
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error to compact bolt store: %w", err)
+	}
+
+	return removed, nil
+}
+
+// Close stops the janitor and closes the file.
+func (b *BoltStore) Close() (err error) {
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+
+	return b.db.Close()
+}
+
+func (b *BoltStore) tx(tx *bolt.Tx) (btx *boltTx) {
+	return &boltTx{bucket: tx.Bucket(tokensBucket), now: b.now()}
+}
+
+func (t *boltTx) Get(key string) (value string, err error) {
+	v := t.bucket.Get([]byte(key))
+	if v == nil || expired(v, t.now) {
+		return "", ErrNotFound
+	}
+
+	return string(v[expiresAtBytes:]), nil
+}
+
+func (t *boltTx) TTL(key string) (ttl time.Duration, err error) {
+	v := t.bucket.Get([]byte(key))
+	if v == nil || expired(v, t.now) {
+		return 0, ErrNotFound
+	}
+
+	at := expiresAt(v)
+	if at.IsZero() {
+		return 0, nil
+	}
+
+	return at.Sub(t.now), nil
+}
+
+func (t *boltTx) Set(key, value string, ttl time.Duration) (err error) {
+	v := make([]byte, expiresAtBytes, expiresAtBytes+len(value))
+
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(v, uint64(t.now.Add(ttl).UnixNano()))
+	}
+
+	return t.bucket.Put([]byte(key), append(v, value...))
+}
+
+func (t *boltTx) Delete(keys ...string) (err error) {
+	for _, key := range keys {
+		if err = t.bucket.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expiresAt decodes the expiry stored ahead of the value; zero means never.
+func expiresAt(v []byte) (t time.Time) {
+	if len(v) < expiresAtBytes {
+		return time.Time{}
+	}
+
+	nanos := binary.BigEndian.Uint64(v[:expiresAtBytes])
+	if nanos == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, int64(nanos))
+}
+
+func expired(v []byte, now time.Time) (expired bool) {
+	at := expiresAt(v)
+
+	return !at.IsZero() && !now.Before(at)
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a TokenStore held in process memory. Expired keys are never
+// returned and are removed by a background janitor; with WithMaxEntries the
+// least recently used key is evicted to make room for a new one.
+type MemoryStore struct {
+	items  map[string]*list.Element
+	lru    *list.List
+	now    func() time.Time
+	done   chan struct{}
+	max    int
+	mu     sync.Mutex
+	closed bool
+}
+
+type memoryItem struct {
+	expiresAt time.Time
+	key       string
+	value     string
+}
+
+// memoryTx reads through to the store and queues writes until Update commits.
+type memoryTx struct {
+	store  *MemoryStore
+	writes []func()
+}
+
+// NewMemoryStore starts the janitor unless WithJanitorInterval(0) is given;
+// Close stops it.
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	o := newOptions(opts)
+
+	m := &MemoryStore{
+		items: map[string]*list.Element{},
+		lru:   list.New(),
+		now:   o.now,
+		done:  make(chan struct{}),
+		max:   o.maxEntries,
+	}
+
+	if o.janitorInterval > 0 {
+		go janitor(o.janitorInterval, m.done, m.removeExpired)
+	}
+
+	return m
+}
+
+// Set ...
+func (m *MemoryStore) Set(key, value string, ttl time.Duration) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	m.set(key, value, ttl)
+
+	return nil
+}
+
+// Get ...
+func (m *MemoryStore) Get(key string) (value string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return "", ErrClosed
+	}
+
+	item, ok := m.get(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return item.value, nil
+}
+
+// Delete ...
+func (m *MemoryStore) Delete(keys ...string) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	m.delete(keys...)
+
+	return nil
+}
+
+// Exists ...
+func (m *MemoryStore) Exists(key string) (exists bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false, ErrClosed
+	}
+
+	_, exists = m.get(key)
+
+	return exists, nil
+}
+
+// Keys ...
+func (m *MemoryStore) Keys(prefix string) (keys []string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	now := m.now()
+
+	for key, e := range m.items {
+		if item, _ := e.Value.(*memoryItem); strings.HasPrefix(key, prefix) && !item.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// Update runs fn holding the store lock, so it never conflicts.
+func (m *MemoryStore) Update(fn func(Tx) error) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	tx := &memoryTx{store: m}
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	for _, write := range tx.writes {
+		write()
+	}
+
+	return nil
+}
+
+// Len returns the number of keys held, including expired ones the janitor has
+// not removed yet.
+func (m *MemoryStore) Len() (n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lru.Len()
+}
+
+// Close stops the janitor and drops every key.
+func (m *MemoryStore) Close() (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+
+	m.closed = true
+	m.items = map[string]*list.Element{}
+	m.lru.Init()
+	close(m.done)
+
+	return nil
+}
+
+func (m *MemoryStore) removeExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+
+	for e := m.lru.Front(); e != nil; {
+		next := e.Next()
+
+		if item, _ := e.Value.(*memoryItem); item.expired(now) {
+			m.lru.Remove(e)
+			delete(m.items, item.key)
+		}
+
+		e = next
+	}
+}
+
+// get returns the live item for key, marking it as recently used.
+func (m *MemoryStore) get(key string) (item *memoryItem, ok bool) {
+	e, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	item, _ = e.Value.(*memoryItem)
+	if item.expired(m.now()) {
+		m.lru.Remove(e)
+		delete(m.items, key)
+
+		return nil, false
+	}
+
+	m.lru.MoveToFront(e)
+
+	return item, true
+}
+
+func (m *MemoryStore) set(key, value string, ttl time.Duration) {
+	item := &memoryItem{key: key, value: value}
+	if ttl > 0 {
+		item.expiresAt = m.now().Add(ttl)
+	}
+
+	if e, ok := m.items[key]; ok {
+		e.Value = item
+		m.lru.MoveToFront(e)
+
+		return
+	}
+
+	m.items[key] = m.lru.PushFront(item)
+
+	for m.max > 0 && m.lru.Len() > m.max {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+
+		if evicted, _ := oldest.Value.(*memoryItem); evicted != nil {
+			delete(m.items, evicted.key)
+		}
+	}
+}
+
+func (m *MemoryStore) delete(keys ...string) {
+	for _, key := range keys {
+		if e, ok := m.items[key]; ok {
+			m.lru.Remove(e)
+			delete(m.items, key)
+		}
+	}
+}
+
+func (t *memoryTx) Get(key string) (value string, err error) {
+	item, ok := t.store.get(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return item.value, nil
+}
+
+func (t *memoryTx) TTL(key string) (ttl time.Duration, err error) {
+	item, ok := t.store.get(key)
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	if item.expiresAt.IsZero() {
+		return 0, nil
+	}
+
+	// The key may expire between get and now.
+	if ttl = item.expiresAt.Sub(t.store.now()); ttl <= 0 {
+		return 0, ErrNotFound
+	}
+
+	return ttl, nil
+}
+
+func (t *memoryTx) Set(key, value string, ttl time.Duration) (err error) {
+	t.writes = append(t.writes, func() {
+		t.store.set(key, value, ttl)
+	})
+
+	return nil
+}
+
+func (t *memoryTx) Delete(keys ...string) (err error) {
+	t.writes = append(t.writes, func() {
+		t.store.delete(keys...)
+	})
+
+	return nil
+}
+
+func (i *memoryItem) expired(now time.Time) (expired bool) {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
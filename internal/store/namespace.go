@@ -0,0 +1,99 @@
+package store
+
+import (
+	"strings"
+	"time"
+)
+
+// Namespace is a view of another TokenStore where every key is prefixed, so
+// several tenants or applications can share it without colliding.
+type Namespace struct {
+	next   TokenStore
+	prefix string
+}
+
+// namespaceTx prefixes the keys of the transaction it wraps.
+type namespaceTx struct {
+	tx     Tx
+	prefix string
+}
+
+// NewNamespace prefixes every key given to next with prefix.
+func NewNamespace(next TokenStore, prefix string) *Namespace {
+	return &Namespace{next: next, prefix: prefix}
+}
+
+// Set ...
+func (n *Namespace) Set(key, value string, ttl time.Duration) (err error) {
+	return n.next.Set(n.prefix+key, value, ttl)
+}
+
+// Get ...
+func (n *Namespace) Get(key string) (value string, err error) {
+	return n.next.Get(n.prefix + key)
+}
+
+// Delete ...
+func (n *Namespace) Delete(keys ...string) (err error) {
+	return n.next.Delete(prefixKeys(n.prefix, keys)...)
+}
+
+// Exists ...
+func (n *Namespace) Exists(key string) (exists bool, err error) {
+	return n.next.Exists(n.prefix + key)
+}
+
+// Keys returns the keys without the namespace prefix.
+func (n *Namespace) Keys(prefix string) (keys []string, err error) {
+	keys, err = n.next.Keys(n.prefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, n.prefix)
+	}
+
+	return keys, nil
+}
+
+// Update ...
+func (n *Namespace) Update(fn func(Tx) error) (err error) {
+	return n.next.Update(func(tx Tx) error {
+		return fn(&namespaceTx{tx: tx, prefix: n.prefix})
+	})
+}
+
+// Close closes the underlying store.
+func (n *Namespace) Close() (err error) {
+	return n.next.Close()
+}
+
+func (t *namespaceTx) Get(key string) (value string, err error) {
+	return t.tx.Get(t.prefix + key)
+}
+
+func (t *namespaceTx) TTL(key string) (ttl time.Duration, err error) {
+	return t.tx.TTL(t.prefix + key)
+}
+
+func (t *namespaceTx) Set(key, value string, ttl time.Duration) (err error) {
+	return t.tx.Set(t.prefix+key, value, ttl)
+}
+
+func (t *namespaceTx) Delete(keys ...string) (err error) {
+	return t.tx.Delete(prefixKeys(t.prefix, keys)...)
+}
+
+func (t *namespaceTx) SetSubject(key, subject string) (err error) {
+	return SetSubject(t.tx, t.prefix+key, subject)
+}
+
+func prefixKeys(prefix string, keys []string) (prefixed []string) {
+	prefixed = make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixed = append(prefixed, prefix+key)
+	}
+
+	return prefixed
+}
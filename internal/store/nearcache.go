@@ -0,0 +1,241 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Invalidator tells the other replicas which keys changed.
+type Invalidator interface {
+	Publish(keys ...string) error
+	Subscribe(fn func(key string)) (stop func() error, err error)
+}
+
+// NearCache keeps recent reads of another TokenStore in process memory for a
+// short TTL, absent keys included. Writes made through any replica sharing the
+// Invalidator evict the key everywhere; a missed invalidation is bounded by
+// the TTL.
+type NearCache struct {
+	next        TokenStore
+	local       *MemoryStore
+	invalidator Invalidator
+	stop        func() error
+	ttl         time.Duration
+	negativeTTL time.Duration
+	generation  uint64
+	mu          sync.Mutex
+}
+
+// nearCacheTx records the keys written by Update so they can be invalidated.
+type nearCacheTx struct {
+	Tx
+	keys []string
+}
+
+const (
+	cachedPresent = "+"
+	cachedAbsent  = "-"
+
+	publishAttempts = 2
+)
+
+// ErrNearCacheTTL is returned for a cache whose entries would never expire,
+// so a missed invalidation could never be corrected.
+var ErrNearCacheTTL = errors.New("near cache TTL must be positive")
+
+// NewNearCache caches reads of next for ttl, and absent keys for the
+// WithNegativeTTL duration. WithMaxEntries bounds the cache and
+// WithInvalidator shares invalidations with other replicas.
+func NewNearCache(next TokenStore, ttl time.Duration, opts ...Option) (c *NearCache, err error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNearCacheTTL, ttl)
+	}
+
+	o := newOptions(opts)
+
+	c = &NearCache{
+		next:        next,
+		local:       NewMemoryStore(opts...),
+		invalidator: o.invalidator,
+		ttl:         ttl,
+		negativeTTL: o.negativeTTL,
+	}
+
+	if c.invalidator != nil {
+		c.stop, err = c.invalidator.Subscribe(func(key string) {
+			c.evict(key)
+		})
+		if err != nil {
+			_ = c.local.Close()
+
+			return nil, fmt.Errorf("error to subscribe to invalidations: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Set ...
+func (c *NearCache) Set(key, value string, ttl time.Duration) (err error) {
+	if err = c.next.Set(key, value, ttl); err != nil {
+		return err
+	}
+
+	c.invalidate(key)
+
+	return nil
+}
+
+// Get ...
+func (c *NearCache) Get(key string) (value string, err error) {
+	if cached, cacheErr := c.local.Get(key); cacheErr == nil {
+		if cached == cachedAbsent {
+			return "", ErrNotFound
+		}
+
+		return strings.TrimPrefix(cached, cachedPresent), nil
+	}
+
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	value, err = c.next.Get(key)
+
+	switch {
+	case err == nil:
+		c.fill(generation, key, cachedPresent+value, c.ttl)
+	case errors.Is(err, ErrNotFound) && c.negativeTTL > 0:
+		c.fill(generation, key, cachedAbsent, c.negativeTTL)
+	}
+
+	return value, err
+}
+
+// Delete ...
+func (c *NearCache) Delete(keys ...string) (err error) {
+	if err = c.next.Delete(keys...); err != nil {
+		return err
+	}
+
+	c.invalidate(keys...)
+
+	return nil
+}
+
+// Exists ...
+func (c *NearCache) Exists(key string) (exists bool, err error) {
+	_, err = c.Get(key)
+
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	}
+
+	return false, err
+}
+
+// Keys reads the underlying store.
+func (c *NearCache) Keys(prefix string) (keys []string, err error) {
+	return c.next.Keys(prefix)
+}
+
+// Update runs fn on the underlying store and then invalidates the keys it
+// wrote. Reads inside fn bypass the cache. Like Set and Delete, it does not
+// fail once the write is committed: an invalidation that cannot be published
+// is logged, and the other replicas see the write within the TTL.
+func (c *NearCache) Update(fn func(Tx) error) (err error) {
+	var keys []string
+
+	err = c.next.Update(func(tx Tx) error {
+		recorder := &nearCacheTx{Tx: tx}
+
+		if fnErr := fn(recorder); fnErr != nil {
+			return fnErr
+		}
+
+		keys = recorder.keys
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		c.invalidate(keys...)
+	}
+
+	return nil
+}
+
+// Close stops listening for invalidations and closes the underlying store.
+func (c *NearCache) Close() (err error) {
+	if c.stop != nil {
+		_ = c.stop()
+	}
+
+	_ = c.local.Close()
+
+	return c.next.Close()
+}
+
+// fill caches value unless an invalidation happened since generation was read.
+func (c *NearCache) fill(generation uint64, key, value string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation == c.generation {
+		_ = c.local.Set(key, value, ttl)
+	}
+}
+
+func (c *NearCache) evict(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	_ = c.local.Delete(keys...)
+}
+
+// invalidate evicts keys here and tells the other replicas, retrying once.
+// The write is already committed, so a failed publish is only logged.
+func (c *NearCache) invalidate(keys ...string) {
+	c.evict(keys...)
+
+	if c.invalidator == nil {
+		return
+	}
+
+	var err error
+
+	for attempt := 0; attempt < publishAttempts; attempt++ {
+		if err = c.invalidator.Publish(keys...); err == nil {
+			return
+		}
+	}
+
+	log.Println("error to publish invalidation:", err)
+}
+
+func (t *nearCacheTx) Set(key, value string, ttl time.Duration) (err error) {
+	t.keys = append(t.keys, key)
+
+	return t.Tx.Set(key, value, ttl)
+}
+
+func (t *nearCacheTx) Delete(keys ...string) (err error) {
+	t.keys = append(t.keys, keys...)
+
+	return t.Tx.Delete(keys...)
+}
+
+func (t *nearCacheTx) SetSubject(key, subject string) (err error) {
+	return SetSubject(t.Tx, key, subject)
+}
@@ -0,0 +1,338 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresStore is a TokenStore kept in the tokens table. Keys are stored as
+// SHA-256 hashes, along with the subject given to SetSubject and when they
+// were first set. Delete marks rows revoked rather than removing them, so
+// revocations stay auditable until the token expires and Sweep deletes it;
+// setting a revoked key again moves its revocation to token_revocations.
+type PostgresStore struct {
+	db   *sql.DB
+	now  func() time.Time
+	done chan struct{}
+}
+
+// postgresTx runs in a SERIALIZABLE transaction; Update retries on conflicts.
+type postgresTx struct {
+	tx  *sql.Tx
+	now time.Time
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+const (
+	serializationFailure = "40001"
+
+	liveToken = `token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2)`
+)
+
+// migrations are applied in order; append new ones, never edit old ones.
+var migrations = []string{
+	`CREATE TABLE tokens (
+		token_hash TEXT PRIMARY KEY,
+		value      TEXT NOT NULL,
+		subject    TEXT NOT NULL DEFAULT '',
+		issued_at  TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX tokens_expires_at_idx ON tokens (expires_at)`,
+	`CREATE INDEX tokens_subject_idx ON tokens (subject)`,
+	`CREATE TABLE token_revocations (
+		token_hash TEXT NOT NULL,
+		subject    TEXT NOT NULL,
+		issued_at  TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ NOT NULL
+	)`,
+	`CREATE INDEX token_revocations_token_hash_idx ON token_revocations (token_hash)`,
+}
+
+// NewPostgresStore migrates the schema and starts the sweeper unless
+// WithJanitorInterval(0) is given; Close stops it and closes db.
+func NewPostgresStore(db *sql.DB, opts ...Option) (p *PostgresStore, err error) {
+	o := newOptions(opts)
+
+	if err = migrate(db); err != nil {
+		return nil, err
+	}
+
+	p = &PostgresStore{db: db, now: o.now, done: make(chan struct{})}
+
+	if o.janitorInterval > 0 {
+		go janitor(o.janitorInterval, p.done, func() {
+			_, _ = p.Sweep()
+		})
+	}
+
+	return p, nil
+}
+
+// Set ...
+func (p *PostgresStore) Set(key, value string, ttl time.Duration) (err error) {
+	return set(p.db, p.now(), key, value, ttl)
+}
+
+// Get ...
+func (p *PostgresStore) Get(key string) (value string, err error) {
+	return get(p.db, p.now(), key, "")
+}
+
+// Delete revokes the keys.
+func (p *PostgresStore) Delete(keys ...string) (err error) {
+	return revoke(p.db, p.now(), keys...)
+}
+
+// Exists ...
+func (p *PostgresStore) Exists(key string) (exists bool, err error) {
+	err = p.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM tokens WHERE `+liveToken+`)`,
+		hashKey(key), p.now()).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error to check token: %w", err)
+	}
+
+	return exists, nil
+}
+
+// Keys fails with ErrNoKeys: only their hashes are stored.
+func (p *PostgresStore) Keys(prefix string) (keys []string, err error) {
+	return nil, ErrNoKeys
+}
+
+// Update runs fn in a SERIALIZABLE transaction, retrying when it conflicts
+// with a concurrent one.
+func (p *PostgresStore) Update(fn func(Tx) error) (err error) {
+	for i := 0; i < maxUpdateRetries; i++ {
+		err = p.update(fn)
+
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != serializationFailure {
+			return err
+		}
+	}
+
+	return ErrConflict
+}
+
+// Sweep deletes every expired row, revoked or not, and the archived
+// revocations of expired tokens, and returns how many rows it deleted.
+func (p *PostgresStore) Sweep() (removed int, err error) {
+	now := p.now()
+
+	for _, table := range []string{"tokens", "token_revocations"} {
+		result, execErr := p.db.Exec(`DELETE FROM `+table+` WHERE expires_at <= $1`, now)
+		if execErr != nil {
+			return removed, fmt.Errorf("error to sweep %s: %w", table, execErr)
+		}
+
+		n, rowsErr := result.RowsAffected()
+		if rowsErr != nil {
+			return removed, fmt.Errorf("error to sweep %s: %w", table, rowsErr)
+		}
+
+		removed += int(n)
+	}
+
+	return removed, nil
+}
+
+// Close stops the sweeper and closes the database.
+func (p *PostgresStore) Close() (err error) {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+
+	return p.db.Close()
+}
+
+func (p *PostgresStore) update(fn func(Tx) error) (err error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(`SET TRANSACTION ISOLATION LEVEL SERIALIZABLE`); err != nil {
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	if err = fn(&postgresTx{tx: tx, now: p.now()}); err != nil {
+		_ = tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (t *postgresTx) Get(key string) (value string, err error) {
+	return get(t.tx, t.now, key, " FOR UPDATE")
+}
+
+func (t *postgresTx) TTL(key string) (ttl time.Duration, err error) {
+	var expiresAt sql.NullTime
+
+	err = t.tx.QueryRow(`SELECT expires_at FROM tokens WHERE `+liveToken+` FOR UPDATE`,
+		hashKey(key), t.now).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("error to get token ttl: %w", err)
+	}
+
+	if !expiresAt.Valid {
+		return 0, nil
+	}
+
+	return expiresAt.Time.Sub(t.now), nil
+}
+
+func (t *postgresTx) Set(key, value string, ttl time.Duration) (err error) {
+	return set(t.tx, t.now, key, value, ttl)
+}
+
+func (t *postgresTx) Delete(keys ...string) (err error) {
+	return revoke(t.tx, t.now, keys...)
+}
+
+// SetSubject records subject in the row of key, which must have been set.
+func (t *postgresTx) SetSubject(key, subject string) (err error) {
+	_, err = t.tx.Exec(`UPDATE tokens SET subject = $2 WHERE token_hash = $1`, hashKey(key), subject)
+	if err != nil {
+		return fmt.Errorf("error to set token subject: %w", err)
+	}
+
+	return nil
+}
+
+// migrate applies the migrations newer than the recorded schema version.
+func migrate(db *sql.DB) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error to migrate: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("error to migrate: %w", err)
+	}
+
+	// Serialize concurrent instances migrating the same database.
+	if _, err = tx.Exec(`LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("error to migrate: %w", err)
+	}
+
+	var version int
+
+	err = tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("error to migrate: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if _, err = tx.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("error to apply migration %d: %w", i+1, err)
+		}
+
+		if _, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return fmt.Errorf("error to apply migration %d: %w", i+1, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("error to migrate: %w", err)
+	}
+
+	return nil
+}
+
+func get(q querier, now time.Time, key, lock string) (value string, err error) {
+	err = q.QueryRow(`SELECT value FROM tokens WHERE `+liveToken+lock, hashKey(key), now).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error to get token: %w", err)
+	}
+
+	return value, nil
+}
+
+func set(q querier, now time.Time, key, value string, ttl time.Duration) (err error) {
+	var expiresAt sql.NullTime
+	if ttl > 0 {
+		expiresAt = sql.NullTime{Time: now.Add(ttl), Valid: true}
+	}
+
+	// A live row keeps when it was issued; a revoked or expired one is issued
+	// anew, after its revocation is archived.
+	_, err = q.Exec(`WITH archived AS (
+			INSERT INTO token_revocations (token_hash, subject, issued_at, expires_at, revoked_at)
+			SELECT token_hash, subject, issued_at, expires_at, revoked_at FROM tokens
+			WHERE token_hash = $1 AND revoked_at IS NOT NULL
+		)
+		INSERT INTO tokens (token_hash, value, issued_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (token_hash) DO UPDATE SET
+			value = EXCLUDED.value,
+			issued_at = CASE
+				WHEN tokens.revoked_at IS NULL AND (tokens.expires_at IS NULL OR tokens.expires_at > $3)
+				THEN tokens.issued_at
+				ELSE EXCLUDED.issued_at
+			END,
+			expires_at = EXCLUDED.expires_at,
+			revoked_at = NULL`,
+		hashKey(key), value, now, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error to set token: %w", err)
+	}
+
+	return nil
+}
+
+func revoke(q querier, now time.Time, keys ...string) (err error) {
+	hashes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		hashes = append(hashes, hashKey(key))
+	}
+
+	_, err = q.Exec(`UPDATE tokens SET revoked_at = $2 WHERE token_hash = ANY($1) AND revoked_at IS NULL`,
+		pq.Array(hashes), now)
+	if err != nil {
+		return fmt.Errorf("error to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+func hashKey(key string) (hash string) {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,526 @@
+package store
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+)
+
+// RedisStore is a TokenStore backed by a Redis server, a Sentinel managed
+// master or a Redis Cluster.
+type RedisStore struct {
+	db      redis.UniversalClient
+	cluster *redis.ClusterClient
+}
+
+// RedisInvalidator is an Invalidator over a Redis pub/sub channel.
+type RedisInvalidator struct {
+	db      redis.UniversalClient
+	channel string
+}
+
+// RedisOptions selects and configures the client built by NewRedisClient.
+type RedisOptions struct {
+	TLSConfig    *tls.Config
+	Mode         string
+	MasterName   string
+	Username     string
+	Password     string
+	Addrs        []string
+	DB           int
+	PoolSize     int
+	MinIdleConns int
+	PoolTimeout  time.Duration
+}
+
+// redisTx watches every key it reads and queues writes until Update commits.
+type redisTx struct {
+	tx     *redis.Tx
+	writes []func(redis.Pipeliner)
+}
+
+// clusterTx locks every key it reads and queues writes until Update commits.
+// A Redis Cluster cannot WATCH keys living in different slots.
+type clusterTx struct {
+	db     *redis.ClusterClient
+	token  string
+	locked []string
+	writes []func(redis.Pipeliner)
+}
+
+// Redis deployments selectable with RedisOptions.Mode.
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+const (
+	lockPrefix     = "lock:"
+	lockTTL        = 5 * time.Second
+	lockRetryDelay = 10 * time.Millisecond
+	scanCount      = 1000
+)
+
+var (
+	ErrRedisOptions = errors.New("invalid redis options")
+
+	errLocked = errors.New("key is locked")
+
+	globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+	// unlockScript deletes the lock only if this transaction still holds it.
+	unlockScript = redis.NewScript(`
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("DEL", KEYS[1])
+		end
+		return 0`)
+)
+
+// NewRedisStore ...
+func NewRedisStore(db redis.UniversalClient) *RedisStore {
+	cluster, _ := db.(*redis.ClusterClient)
+
+	return &RedisStore{db: db, cluster: cluster}
+}
+
+// NewRedisInvalidator ...
+func NewRedisInvalidator(db redis.UniversalClient, channel string) *RedisInvalidator {
+	return &RedisInvalidator{db: db, channel: channel}
+}
+
+// Publish sends one message per key.
+func (i *RedisInvalidator) Publish(keys ...string) (err error) {
+	_, err = i.db.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Publish(i.channel, key)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Subscribe calls fn with every key published until stop is called. The
+// client reconnects by itself; keys published meanwhile are lost.
+func (i *RedisInvalidator) Subscribe(fn func(key string)) (stop func() error, err error) {
+	pubsub := i.db.Subscribe(i.channel)
+
+	// Wait for the confirmation so no key published after Subscribe is missed.
+	if _, err = pubsub.Receive(); err != nil {
+		_ = pubsub.Close()
+
+		return nil, err
+	}
+
+	go func(messages <-chan *redis.Message) {
+		for message := range messages {
+			fn(message.Payload)
+		}
+	}(pubsub.Channel())
+
+	return pubsub.Close, nil
+}
+
+// NewRedisClient connects to a single server, to the master named by
+// MasterName through the Sentinels in Addrs, or to the Cluster nodes in Addrs.
+// With a Username it authenticates as that ACL user (Redis 6+).
+func NewRedisClient(opts RedisOptions) (db redis.UniversalClient, err error) {
+	if len(opts.Addrs) == 0 {
+		return nil, fmt.Errorf("%w: no address", ErrRedisOptions)
+	}
+
+	password, database := opts.Password, opts.DB
+
+	var onConnect func(*redis.Conn) error
+
+	// go-redis v6 only sends AUTH <password>, and SELECT before OnConnect.
+	if opts.Username != "" {
+		onConnect = authenticate(opts.Username, opts.Password, opts.DB)
+		password, database = "", 0
+	}
+
+	switch opts.Mode {
+	case "", RedisStandalone:
+		return redis.NewClient(&redis.Options{
+			Addr:         opts.Addrs[0],
+			OnConnect:    onConnect,
+			Password:     password,
+			DB:           database,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			PoolTimeout:  opts.PoolTimeout,
+			TLSConfig:    opts.TLSConfig,
+		}), nil
+
+	case RedisSentinel:
+		if opts.MasterName == "" {
+			return nil, fmt.Errorf("%w: sentinel needs a master name", ErrRedisOptions)
+		}
+
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    opts.MasterName,
+			SentinelAddrs: opts.Addrs,
+			OnConnect:     onConnect,
+			Password:      password,
+			DB:            database,
+			PoolSize:      opts.PoolSize,
+			MinIdleConns:  opts.MinIdleConns,
+			PoolTimeout:   opts.PoolTimeout,
+			TLSConfig:     opts.TLSConfig,
+		}), nil
+
+	case RedisCluster:
+		if opts.DB != 0 {
+			return nil, fmt.Errorf("%w: cluster only has database 0", ErrRedisOptions)
+		}
+
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opts.Addrs,
+			OnConnect:    onConnect,
+			Password:     password,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			PoolTimeout:  opts.PoolTimeout,
+			TLSConfig:    opts.TLSConfig,
+		}), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown mode %s", ErrRedisOptions, opts.Mode)
+}
+
+// Set ...
+func (r *RedisStore) Set(key, value string, ttl time.Duration) (err error) {
+	return unavailable(r.db.Set(key, value, ttl).Err())
+}
+
+// Get ...
+func (r *RedisStore) Get(key string) (value string, err error) {
+	value, err = r.db.Get(key).Result()
+
+	return value, getError(err)
+}
+
+// Delete ...
+func (r *RedisStore) Delete(keys ...string) (err error) {
+	if r.cluster == nil {
+		return unavailable(r.db.Del(keys...).Err())
+	}
+
+	// A multi-key DEL fails unless every key is in the same slot.
+	_, err = r.cluster.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(key)
+		}
+
+		return nil
+	})
+
+	return unavailable(err)
+}
+
+// Exists ...
+func (r *RedisStore) Exists(key string) (exists bool, err error) {
+	n, err := r.db.Exists(key).Result()
+	if err != nil {
+		return false, unavailable(err)
+	}
+
+	return n == 1, nil
+}
+
+// Keys SCANs the database, or every master of a Cluster.
+func (r *RedisStore) Keys(prefix string) (keys []string, err error) {
+	if r.cluster == nil {
+		return scanKeys(r.db, prefix)
+	}
+
+	var mu sync.Mutex
+
+	err = r.cluster.ForEachMaster(func(client *redis.Client) error {
+		masterKeys, scanErr := scanKeys(client, prefix)
+		if scanErr != nil {
+			return scanErr
+		}
+
+		mu.Lock()
+		keys = append(keys, masterKeys...)
+		mu.Unlock()
+
+		return nil
+	})
+	if err != nil {
+		return nil, unavailable(err)
+	}
+
+	return keys, nil
+}
+
+// Update runs fn in a WATCH/MULTI transaction, retrying when another client
+// modified a key fn read before the commit. On a Cluster the keys fn reads are
+// locked instead, and the writes are applied after fn without MULTI.
+func (r *RedisStore) Update(fn func(Tx) error) (err error) {
+	if r.cluster != nil {
+		return r.updateCluster(fn)
+	}
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		var fnFailed bool
+
+		err = r.db.Watch(func(tx *redis.Tx) error {
+			rtx := &redisTx{tx: tx}
+
+			if fnErr := fn(rtx); fnErr != nil {
+				fnFailed = true
+
+				return fnErr
+			}
+
+			return rtx.commit()
+		})
+
+		switch {
+		case errors.Is(err, redis.TxFailedErr):
+			continue
+		case err != nil && !fnFailed:
+			return unavailable(err)
+		}
+
+		return err
+	}
+
+	return ErrConflict
+}
+
+// Close ...
+func (r *RedisStore) Close() (err error) {
+	return r.db.Close()
+}
+
+func (r *RedisStore) updateCluster(fn func(Tx) error) (err error) {
+	for i := 0; i < maxUpdateRetries; i++ {
+		tx := &clusterTx{db: r.cluster, token: uuid.NewString()}
+
+		if err = fn(tx); err == nil {
+			err = tx.commit()
+		}
+
+		tx.unlock()
+
+		if !errors.Is(err, errLocked) {
+			return err
+		}
+
+		time.Sleep(time.Duration(i+1) * lockRetryDelay)
+	}
+
+	return ErrConflict
+}
+
+func authenticate(username, password string, database int) func(*redis.Conn) error {
+	return func(conn *redis.Conn) (err error) {
+		if err = conn.Process(redis.NewStatusCmd("auth", username, password)); err != nil {
+			return err
+		}
+
+		if database == 0 {
+			return nil
+		}
+
+		return conn.Select(database).Err()
+	}
+}
+
+func scanKeys(db redis.Cmdable, prefix string) (keys []string, err error) {
+	match := globEscaper.Replace(prefix) + "*"
+
+	var (
+		cursor uint64
+		page   []string
+	)
+
+	for {
+		if page, cursor, err = db.Scan(cursor, match, scanCount).Result(); err != nil {
+			return nil, fmt.Errorf("error to scan keys: %w", unavailable(err))
+		}
+
+		keys = append(keys, page...)
+
+		if cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+func (t *redisTx) Get(key string) (value string, err error) {
+	if err = t.tx.Watch(key).Err(); err != nil {
+		return "", unavailable(err)
+	}
+
+	value, err = t.tx.Get(key).Result()
+
+	return value, getError(err)
+}
+
+func (t *redisTx) TTL(key string) (ttl time.Duration, err error) {
+	if err = t.tx.Watch(key).Err(); err != nil {
+		return 0, unavailable(err)
+	}
+
+	ttl, err = t.tx.PTTL(key).Result()
+	if err != nil {
+		return 0, unavailable(err)
+	}
+
+	return remainingTTL(ttl)
+}
+
+func (t *redisTx) Set(key, value string, ttl time.Duration) (err error) {
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		pipe.Set(key, value, ttl)
+	})
+
+	return nil
+}
+
+func (t *redisTx) Delete(keys ...string) (err error) {
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		pipe.Del(keys...)
+	})
+
+	return nil
+}
+
+func (t *redisTx) commit() (err error) {
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	_, err = t.tx.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, write := range t.writes {
+			write(pipe)
+		}
+
+		return nil
+	})
+
+	return unavailable(err)
+}
+
+func (t *clusterTx) Get(key string) (value string, err error) {
+	if err = t.lock(key); err != nil {
+		return "", err
+	}
+
+	value, err = t.db.Get(key).Result()
+
+	return value, getError(err)
+}
+
+func (t *clusterTx) TTL(key string) (ttl time.Duration, err error) {
+	if err = t.lock(key); err != nil {
+		return 0, err
+	}
+
+	ttl, err = t.db.PTTL(key).Result()
+	if err != nil {
+		return 0, unavailable(err)
+	}
+
+	return remainingTTL(ttl)
+}
+
+func (t *clusterTx) Set(key, value string, ttl time.Duration) (err error) {
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		pipe.Set(key, value, ttl)
+	})
+
+	return nil
+}
+
+func (t *clusterTx) Delete(keys ...string) (err error) {
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		for _, key := range keys {
+			pipe.Del(key)
+		}
+	})
+
+	return nil
+}
+
+func (t *clusterTx) lock(key string) (err error) {
+	for _, locked := range t.locked {
+		if locked == key {
+			return nil
+		}
+	}
+
+	ok, err := t.db.SetNX(lockPrefix+key, t.token, lockTTL).Result()
+	if err != nil {
+		return unavailable(err)
+	}
+
+	if !ok {
+		return errLocked
+	}
+
+	t.locked = append(t.locked, key)
+
+	return nil
+}
+
+func (t *clusterTx) unlock() {
+	for _, key := range t.locked {
+		_ = unlockScript.Run(t.db, []string{lockPrefix + key}, t.token).Err()
+	}
+}
+
+func (t *clusterTx) commit() (err error) {
+	if len(t.writes) == 0 {
+		return nil
+	}
+
+	_, err = t.db.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, write := range t.writes {
+			write(pipe)
+		}
+
+		return nil
+	})
+
+	return unavailable(err)
+}
+
+// getError maps the error of a GET: a missing key is ErrNotFound and a key
+// holding another type, which is not ours, is ErrWrongType.
+func getError(err error) error {
+	switch {
+	case errors.Is(err, redis.Nil):
+		return ErrNotFound
+	case err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE"):
+		return fmt.Errorf("%w: %s", ErrWrongType, err)
+	}
+
+	return unavailable(err)
+}
+
+// remainingTTL reads a PTTL reply: -2ms for a missing key and -1ms for one
+// without expiry. A key with less than 1ms left reads 0, which would mean no
+// expiry, so it is reported missing as well.
+func remainingTTL(ttl time.Duration) (remaining time.Duration, err error) {
+	switch {
+	case ttl == -time.Millisecond:
+		return 0, nil
+	case ttl <= 0:
+		return 0, ErrNotFound
+	}
+
+	return ttl, nil
+}
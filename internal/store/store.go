@@ -0,0 +1,167 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// TokenStore keeps tokens and their state. A ttl of 0 means the key never
+// expires. Keys lists the live keys starting with prefix, in no particular
+// order; it scans the whole store and is meant for maintenance jobs.
+type TokenStore interface {
+	Set(key, value string, ttl time.Duration) error
+	Get(key string) (string, error)
+	Delete(keys ...string) error
+	Exists(key string) (bool, error)
+	Keys(prefix string) ([]string, error)
+	Update(fn func(Tx) error) error
+	Close() error
+}
+
+// Tx is the view of a TokenStore passed to Update. Writes are applied
+// atomically when fn returns nil and discarded otherwise; fn must not rely on
+// reading its own writes. TTL returns 0 only for a key that never expires and
+// ErrNotFound for one that is missing or expired.
+type Tx interface {
+	Get(key string) (string, error)
+	TTL(key string) (time.Duration, error)
+	Set(key, value string, ttl time.Duration) error
+	Delete(keys ...string) error
+}
+
+// SubjectRecorder is implemented by the transactions of the stores that keep,
+// for auditing, the subject each key belongs to. Keys may be hashed before
+// they reach the store, so it is told rather than left to find out.
+type SubjectRecorder interface {
+	SetSubject(key, subject string) error
+}
+
+// Option configures the stores that manage expiry themselves.
+type Option func(*options)
+
+type options struct {
+	now             func() time.Time
+	invalidator     Invalidator
+	maxEntries      int
+	janitorInterval time.Duration
+	negativeTTL     time.Duration
+}
+
+// unavailableError is ErrUnavailable keeping the backend error it stands for.
+type unavailableError struct {
+	err error
+}
+
+const (
+	maxUpdateRetries       int = 3
+	defaultJanitorInterval     = time.Minute
+)
+
+var (
+	ErrNotFound = errors.New("key not found")
+	ErrConflict = errors.New("concurrent update, giving up")
+	ErrClosed   = errors.New("store is closed")
+	ErrNoKeys   = errors.New("store cannot list its keys")
+
+	// ErrWrongType is returned by the stores shared with other applications
+	// when a key holds something else than a string value.
+	ErrWrongType = errors.New("key does not hold a string value")
+
+	// ErrUnavailable wraps the failures to reach the backend of a store, as
+	// opposed to the errors of the functions passed to Update.
+	ErrUnavailable = errors.New("store is unavailable")
+)
+
+// WithMaxEntries bounds the store to n keys, evicting the least recently used.
+// 0 means unbounded. Only the MemoryStore and NearCache honour it.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// WithJanitorInterval sets how often expired keys are removed. 0 disables the
+// janitor; expired keys are then only hidden from reads.
+func WithJanitorInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.janitorInterval = interval
+	}
+}
+
+// WithNegativeTTL makes the NearCache remember absent keys for ttl.
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithInvalidator makes the NearCache publish the keys it writes and evict
+// the keys other replicas publish.
+func WithInvalidator(invalidator Invalidator) Option {
+	return func(o *options) {
+		o.invalidator = invalidator
+	}
+}
+
+// WithClock replaces time.Now, for tests.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+func newOptions(opts []Option) (o options) {
+	o = options{now: time.Now, janitorInterval: defaultJanitorInterval}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// janitor calls removeExpired every interval until done is closed.
+func janitor(interval time.Duration, done <-chan struct{}, removeExpired func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			removeExpired()
+		case <-done:
+			return
+		}
+	}
+}
+
+// SetSubject records that the key set in tx belongs to subject, when the
+// store keeps subjects.
+func SetSubject(tx Tx, key, subject string) (err error) {
+	if recorder, ok := tx.(SubjectRecorder); ok {
+		return recorder.SetSubject(key, subject)
+	}
+
+	return nil
+}
+
+// unavailable marks err, if any and not marked yet, as ErrUnavailable.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, ErrUnavailable) {
+		return err
+	}
+
+	return unavailableError{err: err}
+}
+
+func (e unavailableError) Error() string {
+	return ErrUnavailable.Error() + ": " + e.err.Error()
+}
+
+func (e unavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e unavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
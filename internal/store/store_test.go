@@ -0,0 +1,970 @@
+package store_test
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cache/internal/entity/mock"
+	"cache/internal/store"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// clock is a manually advanced time source.
+type clock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+// backend opens an empty store and returns a function that moves its clock.
+type backend struct {
+	open func(t *testing.T) (store.TokenStore, func(time.Duration))
+	name string
+}
+
+// downInvalidator cannot publish invalidations.
+type downInvalidator struct{}
+
+var errTest = errors.New("test error")
+
+func backends() (list []backend) {
+	list = []backend{
+		{
+			name: "Redis",
+			open: func(t *testing.T) (store.TokenStore, func(time.Duration)) {
+				t.Helper()
+
+				mr, err := miniredis.Run()
+				if err != nil {
+					assert.Error(t, err)
+				}
+
+				t.Cleanup(mr.Close)
+
+				return store.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()})), mr.FastForward
+			},
+		},
+		{
+			name: "RedisCluster",
+			open: func(t *testing.T) (store.TokenStore, func(time.Duration)) {
+				t.Helper()
+
+				mr := miniredis.RunT(t)
+
+				db, err := store.NewRedisClient(store.RedisOptions{Mode: store.RedisCluster, Addrs: []string{mr.Addr()}})
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				return store.NewRedisStore(db), mr.FastForward
+			},
+		},
+		{
+			name: "Memory",
+			open: func(t *testing.T) (store.TokenStore, func(time.Duration)) {
+				t.Helper()
+
+				c := newClock()
+
+				return store.NewMemoryStore(store.WithJanitorInterval(0), store.WithClock(c.Now)), c.Advance
+			},
+		},
+		{
+			name: "NearCache",
+			open: func(t *testing.T) (store.TokenStore, func(time.Duration)) {
+				t.Helper()
+
+				c := newClock()
+
+				db, err := store.NewNearCache(
+					store.NewMemoryStore(store.WithJanitorInterval(0), store.WithClock(c.Now)),
+					time.Second,
+					store.WithNegativeTTL(time.Second),
+					store.WithClock(c.Now),
+				)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				return db, c.Advance
+			},
+		},
+		{
+			name: "Namespace",
+			open: func(t *testing.T) (store.TokenStore, func(time.Duration)) {
+				t.Helper()
+
+				c := newClock()
+
+				return store.NewNamespace(
+					store.NewMemoryStore(store.WithJanitorInterval(0), store.WithClock(c.Now)),
+					"tenant:",
+				), c.Advance
+			},
+		},
+		{
+			name: "Bolt",
+			open: func(t *testing.T) (store.TokenStore, func(time.Duration)) {
+				t.Helper()
+
+				c := newClock()
+
+				db, err := store.NewBoltStore(
+					filepath.Join(t.TempDir(), "tokens.db"),
+					store.WithJanitorInterval(0),
+					store.WithClock(c.Now),
+				)
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				return db, c.Advance
+			},
+		},
+	}
+
+	// The Postgres store also runs against a real server when one is given,
+	// e.g. POSTGRES_TEST_DSN=postgres://postgres@localhost/test?sslmode=disable.
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		list = append(list, backend{
+			name: "Postgres",
+			open: func(t *testing.T) (store.TokenStore, func(time.Duration)) {
+				t.Helper()
+
+				c := newClock()
+
+				db, err := store.NewPostgresStore(postgresSchema(t, dsn), store.WithJanitorInterval(0), store.WithClock(c.Now))
+				if err != nil {
+					assert.Fail(t, err.Error())
+				}
+
+				return db, c.Advance
+			},
+		})
+	}
+
+	return list
+}
+
+// postgresSchema connects to dsn with a fresh schema as the search path, so
+// parallel tests do not share the tokens table.
+func postgresSchema(t *testing.T, dsn string) (db *sql.DB) {
+	t.Helper()
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	schema := "test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	if _, err = admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(func() {
+		_, _ = admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	})
+
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	db, err = sql.Open("postgres", dsn+separator+"search_path="+schema)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	return db
+}
+
+func newClock() *clock {
+	return &clock{now: time.Now()}
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func (downInvalidator) Publish(...string) (err error) {
+	return errTest
+}
+
+func (downInvalidator) Subscribe(func(string)) (stop func() error, err error) {
+	return func() error { return nil }, nil
+}
+
+func TestTokenStore(t *testing.T) {
+	t.Parallel()
+
+	for _, b := range backends() {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _ := b.open(t)
+
+			_, err := db.Get(mock.TokenTest)
+			assert.ErrorIs(t, err, store.ErrNotFound)
+
+			assert.NoError(t, db.Set(mock.TokenTest, "1", time.Minute))
+
+			value, err := db.Get(mock.TokenTest)
+			assert.NoError(t, err)
+			assert.Equal(t, "1", value)
+
+			exists, err := db.Exists(mock.TokenTest)
+			assert.NoError(t, err)
+			assert.True(t, exists)
+
+			assert.NoError(t, db.Delete(mock.TokenTest, "unknown"))
+
+			exists, err = db.Exists(mock.TokenTest)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			assert.NoError(t, db.Close())
+			assert.Error(t, db.Set(mock.TokenTest, "1", time.Minute))
+		})
+	}
+}
+
+func TestTokenStoreTTL(t *testing.T) {
+	t.Parallel()
+
+	for _, b := range backends() {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, advance := b.open(t)
+
+			assert.NoError(t, db.Set("short", "1", time.Minute))
+			assert.NoError(t, db.Set("forever", "1", 0))
+
+			advance(2 * time.Minute)
+
+			exists, err := db.Exists("short")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			_, err = db.Get("short")
+			assert.ErrorIs(t, err, store.ErrNotFound)
+
+			exists, err = db.Exists("forever")
+			assert.NoError(t, err)
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestTokenStoreKeys(t *testing.T) {
+	t.Parallel()
+
+	for _, b := range backends() {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, advance := b.open(t)
+
+			assert.NoError(t, db.Set("refresh:a", "1", 0))
+			assert.NoError(t, db.Set("refresh:b", "1", time.Minute))
+			assert.NoError(t, db.Set("refresh:*", "1", 0))
+			assert.NoError(t, db.Set("refresh_family:a", "1", 0))
+			assert.NoError(t, db.Set("a", "1", 0))
+
+			advance(2 * time.Minute)
+
+			keys, err := db.Keys("refresh:")
+			if errors.Is(err, store.ErrNoKeys) {
+				t.Skip("store cannot list its keys")
+			}
+
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, []string{"refresh:a", "refresh:*"}, keys)
+
+			keys, err = db.Keys("refresh:*")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"refresh:*"}, keys)
+		})
+	}
+}
+
+func TestTokenStoreUpdate(t *testing.T) {
+	t.Parallel()
+
+	for _, b := range backends() {
+		b := b
+		t.Run(b.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _ := b.open(t)
+
+			assert.NoError(t, db.Set("a", "1", time.Hour))
+			assert.NoError(t, db.Set("b", "1", 0))
+			assert.NoError(t, db.Set("d", "1", 500*time.Millisecond))
+
+			err := db.Update(func(tx store.Tx) error {
+				ttl, err := tx.TTL("a")
+				assert.NoError(t, err)
+				assert.InDelta(t, time.Hour, ttl, float64(time.Second))
+
+				ttl, err = tx.TTL("b")
+				assert.NoError(t, err)
+				assert.Zero(t, ttl)
+
+				// Under a second left is not mistaken for no expiry.
+				ttl, err = tx.TTL("d")
+				assert.NoError(t, err)
+				assert.InDelta(t, 500*time.Millisecond, ttl, float64(100*time.Millisecond))
+
+				_, err = tx.TTL("c")
+				assert.ErrorIs(t, err, store.ErrNotFound)
+
+				_, err = tx.Get("c")
+				assert.ErrorIs(t, err, store.ErrNotFound)
+
+				assert.NoError(t, tx.Set("c", "2", time.Hour))
+
+				return tx.Delete("a")
+			})
+			assert.NoError(t, err)
+
+			value, err := db.Get("c")
+			assert.NoError(t, err)
+			assert.Equal(t, "2", value)
+
+			exists, err := db.Exists("a")
+			assert.NoError(t, err)
+			assert.False(t, exists)
+
+			err = db.Update(func(tx store.Tx) error {
+				assert.NoError(t, tx.Delete("b"))
+
+				return errTest
+			})
+			assert.ErrorIs(t, err, errTest)
+
+			exists, err = db.Exists("b")
+			assert.NoError(t, err)
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestMemoryStoreMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	db := store.NewMemoryStore(store.WithMaxEntries(2), store.WithJanitorInterval(0))
+	defer db.Close()
+
+	assert.NoError(t, db.Set("a", "1", 0))
+	assert.NoError(t, db.Set("b", "1", 0))
+
+	// Reading a makes b the least recently used key.
+	_, err := db.Get("a")
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Set("c", "1", 0))
+
+	var exists bool
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		exists, err = db.Exists(key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, key)
+	}
+}
+
+func TestMemoryStoreJanitor(t *testing.T) {
+	t.Parallel()
+
+	c := newClock()
+
+	db := store.NewMemoryStore(store.WithJanitorInterval(time.Millisecond), store.WithClock(c.Now))
+	defer db.Close()
+
+	assert.NoError(t, db.Set("a", "1", time.Minute))
+	assert.NoError(t, db.Set("b", "1", time.Hour))
+
+	c.Advance(2 * time.Minute)
+
+	assert.Eventually(t, func() bool {
+		return db.Len() == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestMemoryStoreConcurrency(t *testing.T) {
+	t.Parallel()
+
+	db := store.NewMemoryStore(store.WithMaxEntries(50))
+	defer db.Close()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				key := strconv.Itoa(i*100 + j)
+
+				assert.NoError(t, db.Set(key, "1", time.Minute))
+				_, _ = db.Exists(key)
+				assert.NoError(t, db.Update(func(tx store.Tx) error {
+					return tx.Delete(key)
+				}))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.Zero(t, db.Len())
+}
+
+func TestBoltStoreCompact(t *testing.T) {
+	t.Parallel()
+
+	c := newClock()
+	path := filepath.Join(t.TempDir(), "tokens.db")
+
+	db, err := store.NewBoltStore(path, store.WithJanitorInterval(0), store.WithClock(c.Now))
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, db.Set(strconv.Itoa(i), "1", time.Duration(i+1)*time.Minute))
+	}
+
+	assert.NoError(t, db.Set("forever", "1", 0))
+
+	c.Advance(5 * time.Minute)
+
+	removed, err := db.Compact()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, removed)
+
+	// Tokens survive reopening the file.
+	assert.NoError(t, db.Close())
+
+	db, err = store.NewBoltStore(path, store.WithJanitorInterval(0), store.WithClock(c.Now))
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+	defer db.Close()
+
+	var exists bool
+
+	for key, want := range map[string]bool{"4": false, "5": true, "9": true, "forever": true} {
+		exists, err = db.Exists(key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, key)
+	}
+
+	removed, err = db.Compact()
+	assert.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestPostgresStoreMigrate(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name      string
+		outErr    string
+		inVersion int
+		outApply  int
+	}{
+		{
+			name:      mock.NameNoError,
+			inVersion: 0,
+			outApply:  5,
+		},
+		{
+			name:      mock.NameNoError + "UpToDate",
+			inVersion: 5,
+			outApply:  0,
+		},
+		{
+			name:      "ErrorMigration",
+			inVersion: 1,
+			outErr:    "error to apply migration 2",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, sqlMock, err := sqlmock.New()
+			if err != nil {
+				assert.Fail(t, err.Error())
+			}
+
+			sqlMock.ExpectBegin()
+			sqlMock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+			sqlMock.ExpectExec(`LOCK TABLE schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+			sqlMock.ExpectQuery(`SELECT COALESCE\(MAX\(version\), 0\) FROM schema_migrations`).
+				WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(tt.inVersion))
+
+			if tt.outErr != "" {
+				sqlMock.ExpectExec(`CREATE INDEX tokens_expires_at_idx`).WillReturnError(errTest)
+				sqlMock.ExpectRollback()
+			} else {
+				for i := tt.inVersion; i < tt.inVersion+tt.outApply; i++ {
+					sqlMock.ExpectExec(`CREATE`).WillReturnResult(sqlmock.NewResult(0, 0))
+					sqlMock.ExpectExec(`INSERT INTO schema_migrations`).
+						WithArgs(i + 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+
+				sqlMock.ExpectCommit()
+			}
+
+			_, err = store.NewPostgresStore(conn, store.WithJanitorInterval(0))
+			if tt.outErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.outErr)
+			}
+
+			assert.NoError(t, sqlMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1"}`))
+	token := "refresh:header." + payload + ".signature"
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+
+	conn, sqlMock, err := sqlmock.New()
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	sqlMock.MatchExpectationsInOrder(true)
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`LOCK TABLE schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectQuery(`SELECT COALESCE`).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+	sqlMock.ExpectCommit()
+
+	db, err := store.NewPostgresStore(conn, store.WithJanitorInterval(0), store.WithClock(func() time.Time {
+		return now
+	}))
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	// Set stores the hash of the key, archiving a revocation and keeping
+	// when a live key was issued.
+	sqlMock.ExpectExec(`INSERT INTO token_revocations .* WHERE token_hash = \$1 AND revoked_at IS NOT NULL.*`+
+		`INSERT INTO tokens .* THEN tokens.issued_at`).
+		WithArgs(hash, "1", now, sql.NullTime{Time: now.Add(time.Minute), Valid: true}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, db.Set(token, "1", time.Minute))
+
+	// The subject is given explicitly, since the key may be a hash already.
+	sqlMock.ExpectBegin()
+	sqlMock.ExpectExec(`SET TRANSACTION ISOLATION LEVEL SERIALIZABLE`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sqlMock.ExpectExec(`INSERT INTO tokens`).WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectExec(`UPDATE tokens SET subject = \$2 WHERE token_hash = \$1`).
+		WithArgs(hash, "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	sqlMock.ExpectCommit()
+
+	err = db.Update(func(tx store.Tx) error {
+		if setErr := tx.Set(token, "1", time.Minute); setErr != nil {
+			return setErr
+		}
+
+		return store.SetSubject(tx, token, "1")
+	})
+	assert.NoError(t, err)
+
+	sqlMock.ExpectQuery(`SELECT value FROM tokens WHERE token_hash = \$1 AND revoked_at IS NULL`).
+		WithArgs(hash, now).WillReturnRows(sqlmock.NewRows([]string{"value"}))
+
+	_, err = db.Get(token)
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	// Delete revokes instead of removing the row.
+	sqlMock.ExpectExec(`UPDATE tokens SET revoked_at = \$2 WHERE token_hash = ANY\(\$1\)`).
+		WithArgs(pq.Array([]string{hash}), now).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, db.Delete(token))
+
+	sqlMock.ExpectExec(`DELETE FROM tokens WHERE expires_at <= \$1`).
+		WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 4))
+	sqlMock.ExpectExec(`DELETE FROM token_revocations WHERE expires_at <= \$1`).
+		WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	removed, err := db.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, removed)
+
+	// Update gives up after repeated serialization failures.
+	for i := 0; i < 3; i++ {
+		sqlMock.ExpectBegin()
+		sqlMock.ExpectExec(`SET TRANSACTION ISOLATION LEVEL SERIALIZABLE`).WillReturnResult(sqlmock.NewResult(0, 0))
+		sqlMock.ExpectQuery(`SELECT value FROM tokens WHERE .* FOR UPDATE`).
+			WillReturnError(&pq.Error{Code: "40001"})
+		sqlMock.ExpectRollback()
+	}
+
+	err = db.Update(func(tx store.Tx) error {
+		_, getErr := tx.Get(token)
+
+		return getErr
+	})
+	assert.ErrorIs(t, err, store.ErrConflict)
+
+	assert.NoError(t, sqlMock.ExpectationsWereMet())
+}
+
+func TestNewRedisClient(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name       string
+		outErr     string
+		inOpts     store.RedisOptions
+		inUsername string
+		inPassword string
+	}{
+		{
+			name:   mock.NameNoError,
+			inOpts: store.RedisOptions{},
+		},
+		{
+			name:       mock.NameNoError + "ACL",
+			inOpts:     store.RedisOptions{Username: "cache", Password: "secret", DB: 2},
+			inUsername: "cache",
+			inPassword: "secret",
+		},
+		{
+			name:       "ErrorACL",
+			inOpts:     store.RedisOptions{Username: "cache", Password: "wrong"},
+			inUsername: "cache",
+			inPassword: "secret",
+			outErr:     "WRONGPASS",
+		},
+		{
+			name:       mock.NameNoError + "Password",
+			inOpts:     store.RedisOptions{Password: "secret", DB: 1},
+			inPassword: "secret",
+		},
+		{
+			name:   mock.NameNoError + "Cluster",
+			inOpts: store.RedisOptions{Mode: store.RedisCluster},
+		},
+		{
+			name:   "ErrorClusterDB",
+			inOpts: store.RedisOptions{Mode: store.RedisCluster, DB: 1},
+			outErr: store.ErrRedisOptions.Error(),
+		},
+		{
+			name:   "ErrorSentinelMasterName",
+			inOpts: store.RedisOptions{Mode: store.RedisSentinel},
+			outErr: store.ErrRedisOptions.Error(),
+		},
+		{
+			name:   "ErrorMode",
+			inOpts: store.RedisOptions{Mode: "unknown"},
+			outErr: store.ErrRedisOptions.Error(),
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mr := miniredis.RunT(t)
+
+			switch {
+			case tt.inUsername != "":
+				mr.RequireUserAuth(tt.inUsername, tt.inPassword)
+			case tt.inPassword != "":
+				mr.RequireAuth(tt.inPassword)
+			}
+
+			tt.inOpts.Addrs = []string{mr.Addr()}
+
+			client, err := store.NewRedisClient(tt.inOpts)
+			if err == nil {
+				defer client.Close()
+
+				err = store.NewRedisStore(client).Set(mock.TokenTest, "1", 0)
+			}
+
+			if tt.outErr != "" {
+				assert.ErrorContains(t, err, tt.outErr)
+
+				return
+			}
+
+			assert.NoError(t, err)
+
+			value, err := mr.DB(tt.inOpts.DB).Get(mock.TokenTest)
+			assert.NoError(t, err)
+			assert.Equal(t, "1", value)
+		})
+	}
+}
+
+func TestRedisStoreClusterLocked(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+
+	client, err := store.NewRedisClient(store.RedisOptions{Mode: store.RedisCluster, Addrs: []string{mr.Addr()}})
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	db := store.NewRedisStore(client)
+	defer db.Close()
+
+	assert.NoError(t, db.Set("a", "1", 0))
+
+	// Another instance holds the lock on a.
+	assert.NoError(t, mr.Set("lock:a", "other"))
+
+	err = db.Update(func(tx store.Tx) error {
+		if _, getErr := tx.Get("a"); getErr != nil {
+			return getErr
+		}
+
+		return tx.Delete("a")
+	})
+	assert.ErrorIs(t, err, store.ErrConflict)
+	assert.True(t, mr.Exists("a"))
+
+	mr.Del("lock:a")
+
+	err = db.Update(func(tx store.Tx) error {
+		if _, getErr := tx.Get("a"); getErr != nil {
+			return getErr
+		}
+
+		return tx.Delete("a")
+	})
+	assert.NoError(t, err)
+	assert.False(t, mr.Exists("a"))
+	assert.False(t, mr.Exists("lock:a"))
+}
+
+func TestRedisStoreUnavailable(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+
+	db := store.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	defer db.Close()
+
+	errFn := errors.New("fn failed")
+
+	err := db.Update(func(store.Tx) error {
+		return errFn
+	})
+	assert.ErrorIs(t, err, errFn)
+	assert.NotErrorIs(t, err, store.ErrUnavailable)
+
+	mr.HSet(mock.TokenTest, "field", "value")
+
+	_, err = db.Get(mock.TokenTest)
+	assert.ErrorIs(t, err, store.ErrWrongType)
+	assert.NotErrorIs(t, err, store.ErrUnavailable)
+
+	mr.Close()
+
+	assert.ErrorIs(t, db.Set(mock.TokenTest, "1", 0), store.ErrUnavailable)
+
+	_, err = db.Get(mock.TokenTest)
+	assert.ErrorIs(t, err, store.ErrUnavailable)
+
+	_, err = db.Exists(mock.TokenTest)
+	assert.ErrorIs(t, err, store.ErrUnavailable)
+
+	assert.ErrorIs(t, db.Delete(mock.TokenTest), store.ErrUnavailable)
+
+	_, err = db.Keys("")
+	assert.ErrorIs(t, err, store.ErrUnavailable)
+
+	err = db.Update(func(tx store.Tx) error {
+		_, getErr := tx.Get(mock.TokenTest)
+
+		return getErr
+	})
+	assert.ErrorIs(t, err, store.ErrUnavailable)
+}
+
+func TestNearCache(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+
+	replica := func() *store.NearCache {
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+		db, err := store.NewNearCache(
+			store.NewRedisStore(client),
+			time.Minute,
+			store.WithNegativeTTL(time.Minute),
+			store.WithMaxEntries(10),
+			store.WithInvalidator(store.NewRedisInvalidator(client, "invalidate")),
+		)
+		if err != nil {
+			assert.Fail(t, err.Error())
+		}
+
+		t.Cleanup(func() {
+			db.Close()
+		})
+
+		return db
+	}
+
+	a, b := replica(), replica()
+
+	exists := func(db store.TokenStore, key string) bool {
+		exists, err := db.Exists(key)
+		assert.NoError(t, err)
+
+		return exists
+	}
+
+	assert.NoError(t, mr.Set(mock.TokenTest, "1"))
+
+	// Only the first check reaches Redis, absent keys included.
+	count := mr.CommandCount()
+
+	assert.True(t, exists(a, mock.TokenTest))
+	assert.True(t, exists(a, mock.TokenTest))
+	assert.False(t, exists(a, "unknown"))
+	assert.False(t, exists(a, "unknown"))
+	assert.Equal(t, count+2, mr.CommandCount())
+
+	// Writes on b evict the entries cached by a.
+	assert.NoError(t, b.Delete(mock.TokenTest))
+	assert.NoError(t, b.Set("unknown", "1", time.Minute))
+
+	assert.Eventually(t, func() bool {
+		return !exists(a, mock.TokenTest) && exists(a, "unknown")
+	}, time.Second, 10*time.Millisecond)
+
+	// So do writes made inside a transaction.
+	assert.NoError(t, b.Update(func(tx store.Tx) error {
+		return tx.Delete("unknown")
+	}))
+
+	assert.Eventually(t, func() bool {
+		return !exists(a, "unknown")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNearCacheInvalidatorDown(t *testing.T) {
+	t.Parallel()
+
+	_, err := store.NewNearCache(store.NewMemoryStore(store.WithJanitorInterval(0)), 0)
+	assert.ErrorIs(t, err, store.ErrNearCacheTTL)
+
+	next := store.NewMemoryStore(store.WithJanitorInterval(0))
+
+	db, err := store.NewNearCache(next, time.Minute, store.WithInvalidator(downInvalidator{}))
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	defer db.Close()
+
+	// The writes are committed and evict the local entry even though the
+	// other replicas cannot be told.
+	assert.NoError(t, db.Set(mock.TokenTest, "1", time.Minute))
+
+	exists, err := db.Exists(mock.TokenTest)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, db.Delete(mock.TokenTest))
+
+	exists, err = db.Exists(mock.TokenTest)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, db.Update(func(tx store.Tx) error {
+		return tx.Set(mock.TokenTest, "2", time.Minute)
+	}))
+
+	value, err := db.Get(mock.TokenTest)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", value)
+
+	value, err = next.Get(mock.TokenTest)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", value)
+}
+
+func TestNamespace(t *testing.T) {
+	t.Parallel()
+
+	db := store.NewMemoryStore(store.WithJanitorInterval(0))
+	a := store.NewNamespace(db, "a:")
+	b := store.NewNamespace(db, "b:")
+
+	assert.NoError(t, a.Set("token", "1", 0))
+
+	exists, err := b.Exists("token")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	exists, err = db.Exists("a:token")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	err = b.Update(func(tx store.Tx) error {
+		return tx.Set("token", "2", 0)
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Delete("token"))
+
+	value, err := db.Get("b:token")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", value)
+
+	keys, err := b.Keys("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token"}, keys)
+}
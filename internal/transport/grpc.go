@@ -0,0 +1,324 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"cache/internal/endpoint"
+	"cache/internal/entity"
+	"cache/internal/pb"
+	"cache/internal/service"
+
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer is the pb.TokenServer over the token endpoints.
+type GRPCServer struct {
+	pb.UnimplementedTokenServer
+	generate    grpctransport.Handler
+	extract     grpctransport.Handler
+	setToken    grpctransport.Handler
+	deleteToken grpctransport.Handler
+	checkToken  grpctransport.Handler
+}
+
+// errorDomain names this service in the ErrorInfo of gRPC statuses.
+const errorDomain = "cache"
+
+// grpcCodes maps the codes of ErrorStatus to gRPC status codes.
+var grpcCodes = map[string]codes.Code{
+	entity.CodeInvalidRequest:      codes.InvalidArgument,
+	entity.CodeInvalidToken:        codes.Unauthenticated,
+	entity.CodeTokenExpired:        codes.Unauthenticated,
+	entity.CodeInvalidRefreshToken: codes.Unauthenticated,
+	entity.CodeForbidden:           codes.PermissionDenied,
+	entity.CodeTooManySessions:     codes.ResourceExhausted,
+	entity.CodeConflict:            codes.Aborted,
+	entity.CodeUnavailable:         codes.Unavailable,
+	entity.CodeInternal:            codes.Internal,
+}
+
+// NewGRPCServer serves the Generate, Extract, SetToken, DeleteToken and
+// CheckToken endpoints of svc over gRPC.
+func NewGRPCServer(svc service.Service, opts ...grpctransport.ServerOption) *GRPCServer {
+	return &GRPCServer{
+		generate: grpctransport.NewServer(
+			endpoint.MakeGenerateTokenEndpoint(svc),
+			decodeGRPCGenerateRequest,
+			encodeGRPCGenerateReply,
+			opts...,
+		),
+		extract: grpctransport.NewServer(
+			endpoint.MakeExtractTokenEndpoint(svc),
+			decodeGRPCExtractRequest,
+			encodeGRPCExtractReply,
+			opts...,
+		),
+		setToken: grpctransport.NewServer(
+			endpoint.MakeStoreTokenEndpoint(svc),
+			decodeGRPCSetTokenRequest,
+			encodeGRPCSetTokenReply,
+			opts...,
+		),
+		deleteToken: grpctransport.NewServer(
+			endpoint.MakeManageTokenEndpoint(svc, service.NewDeleteTokenState()),
+			decodeGRPCTokenRequest,
+			encodeGRPCDeleteTokenReply,
+			opts...,
+		),
+		checkToken: grpctransport.NewServer(
+			endpoint.MakeCheckTokenEndpoint(svc),
+			decodeGRPCTokenRequest,
+			encodeGRPCCheckTokenReply,
+			opts...,
+		),
+	}
+}
+
+// Generate ...
+func (s *GRPCServer) Generate(ctx context.Context, req *pb.GenerateRequest) (*pb.GenerateReply, error) {
+	ctx, reply, err := s.generate.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, GRPCError(ctx, err)
+	}
+
+	generateReply, _ := reply.(*pb.GenerateReply)
+
+	return generateReply, nil
+}
+
+// Extract ...
+func (s *GRPCServer) Extract(ctx context.Context, req *pb.ExtractRequest) (*pb.ExtractReply, error) {
+	ctx, reply, err := s.extract.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, GRPCError(ctx, err)
+	}
+
+	extractReply, _ := reply.(*pb.ExtractReply)
+
+	return extractReply, nil
+}
+
+// SetToken ...
+func (s *GRPCServer) SetToken(ctx context.Context, req *pb.SetTokenRequest) (*pb.SetTokenReply, error) {
+	ctx, reply, err := s.setToken.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, GRPCError(ctx, err)
+	}
+
+	setTokenReply, _ := reply.(*pb.SetTokenReply)
+
+	return setTokenReply, nil
+}
+
+// DeleteToken ...
+func (s *GRPCServer) DeleteToken(ctx context.Context, req *pb.TokenRequest) (*pb.DeleteTokenReply, error) {
+	ctx, reply, err := s.deleteToken.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, GRPCError(ctx, err)
+	}
+
+	deleteTokenReply, _ := reply.(*pb.DeleteTokenReply)
+
+	return deleteTokenReply, nil
+}
+
+// CheckToken ...
+func (s *GRPCServer) CheckToken(ctx context.Context, req *pb.TokenRequest) (*pb.CheckTokenReply, error) {
+	ctx, reply, err := s.checkToken.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, GRPCError(ctx, err)
+	}
+
+	checkTokenReply, _ := reply.(*pb.CheckTokenReply)
+
+	return checkTokenReply, nil
+}
+
+// GRPCError is the gRPC counterpart of ErrorEncoder: a status with the code
+// err maps to, carrying the envelope code in an ErrorInfo and the request id
+// in a RequestInfo. Server errors do not expose their message.
+func GRPCError(ctx context.Context, err error) error {
+	httpStatus, code := ErrorStatus(err)
+
+	message := err.Error()
+	if httpStatus >= http.StatusInternalServerError {
+		message = http.StatusText(httpStatus)
+	}
+
+	st := status.New(grpcCodes[code], message)
+
+	withDetails, detailsErr := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: code, Domain: errorDomain},
+		&errdetails.RequestInfo{RequestId: RequestIDFromContext(ctx)},
+	)
+	if detailsErr == nil {
+		st = withDetails
+	}
+
+	return st.Err()
+}
+
+// RequestIDFromMetadata is the gRPC counterpart of PopulateRequestID, reading
+// the request id from the metadata.
+func RequestIDFromMetadata(ctx context.Context, md metadata.MD) context.Context {
+	var requestID string
+
+	if values := md.Get(RequestIDHeader); len(values) != 0 {
+		requestID = values[0]
+	}
+
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = uuid.NewString()
+	}
+
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// TenantFromMetadata is the gRPC counterpart of TenantFromHeader.
+func TenantFromMetadata(key string) grpctransport.ServerRequestFunc {
+	return func(ctx context.Context, md metadata.MD) context.Context {
+		if values := md.Get(key); len(values) != 0 && values[0] != "" {
+			return service.ContextWithTenant(ctx, values[0])
+		}
+
+		return ctx
+	}
+}
+
+// ClientFromMetadata is the gRPC counterpart of ClientFromRequest, taking the
+// address of the peer.
+func ClientFromMetadata(ctx context.Context, md metadata.MD) context.Context {
+	var client service.Client
+
+	if values := md.Get("user-agent"); len(values) != 0 {
+		client.UserAgent = values[0]
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client.IP = p.Addr.String()
+
+		if host, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = host
+		}
+	}
+
+	return service.ContextWithClient(ctx, client)
+}
+
+func decodeGRPCGenerateRequest(_ context.Context, request any) (any, error) {
+	req, ok := request.(*pb.GenerateRequest)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type *pb.GenerateRequest", ErrDecode)
+	}
+
+	return entity.IDUsernameEmailSecretRequest{
+		ID:       int(req.GetId()),
+		Username: req.GetUsername(),
+		Email:    req.GetEmail(),
+		Secret:   req.GetSecret(),
+	}, nil
+}
+
+func decodeGRPCExtractRequest(_ context.Context, request any) (any, error) {
+	req, ok := request.(*pb.ExtractRequest)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type *pb.ExtractRequest", ErrDecode)
+	}
+
+	return entity.TokenSecretRequest{Token: req.GetToken(), Secret: req.GetSecret()}, nil
+}
+
+func decodeGRPCSetTokenRequest(_ context.Context, request any) (any, error) {
+	req, ok := request.(*pb.SetTokenRequest)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type *pb.SetTokenRequest", ErrDecode)
+	}
+
+	return entity.Token{Token: req.GetToken(), TTL: req.GetTtl()}, nil
+}
+
+func decodeGRPCTokenRequest(_ context.Context, request any) (any, error) {
+	req, ok := request.(*pb.TokenRequest)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type *pb.TokenRequest", ErrDecode)
+	}
+
+	return entity.Token{Token: req.GetToken()}, nil
+}
+
+func encodeGRPCGenerateReply(_ context.Context, response any) (any, error) {
+	resp, ok := response.(entity.TokenIssuedAtExpiresAtErrResponse)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type TokenIssuedAtExpiresAtErrResponse", ErrEncode)
+	}
+
+	return &pb.GenerateReply{
+		Token:        resp.Token,
+		RefreshToken: resp.RefreshToken,
+		Iat:          resp.IssuedAt,
+		Exp:          resp.ExpiresAt,
+	}, nil
+}
+
+func encodeGRPCExtractReply(_ context.Context, response any) (any, error) {
+	resp, ok := response.(entity.IDUsernameEmailErrResponse)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type IDUsernameEmailErrResponse", ErrEncode)
+	}
+
+	return &pb.ExtractReply{
+		Id:       int64(resp.ID),
+		Username: resp.Username,
+		Email:    resp.Email,
+		Iat:      resp.IssuedAt,
+		Exp:      resp.ExpiresAt,
+	}, nil
+}
+
+func encodeGRPCSetTokenReply(_ context.Context, response any) (any, error) {
+	resp, ok := response.(entity.EvictedErrResponse)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type EvictedErrResponse", ErrEncode)
+	}
+
+	reply := &pb.SetTokenReply{Evicted: make([]*pb.Session, 0, len(resp.Evicted))}
+
+	for _, session := range resp.Evicted {
+		reply.Evicted = append(reply.Evicted, &pb.Session{
+			Id:        session.ID,
+			Type:      session.Type,
+			UserAgent: session.UserAgent,
+			Ip:        session.IP,
+			Iat:       session.IssuedAt,
+			Exp:       session.ExpiresAt,
+		})
+	}
+
+	return reply, nil
+}
+
+func encodeGRPCDeleteTokenReply(_ context.Context, response any) (any, error) {
+	if _, ok := response.(entity.ErrorResponse); !ok {
+		return nil, fmt.Errorf("%w: isn't of type ErrorResponse", ErrEncode)
+	}
+
+	return &pb.DeleteTokenReply{}, nil
+}
+
+func encodeGRPCCheckTokenReply(_ context.Context, response any) (any, error) {
+	resp, ok := response.(entity.CheckErrResponse)
+	if !ok {
+		return nil, fmt.Errorf("%w: isn't of type CheckErrResponse", ErrEncode)
+	}
+
+	return &pb.CheckTokenReply{Check: resp.Check}, nil
+}
@@ -2,29 +2,202 @@ package transport
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"cache/internal/endpoint"
 	"cache/internal/entity"
+	"cache/internal/service"
+	"cache/internal/store"
 
 	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// requestIDKey is the context key of the request id.
+type requestIDKey struct{}
+
+const (
+	// RequestIDHeader carries the request id, taken from the client if valid.
+	RequestIDHeader = "X-Request-ID"
+
+	bearerScheme = "Bearer"
+
+	// Identity headers of EncodeAuthResponse.
+	UserIDHeader   = "X-User-Id"
+	UsernameHeader = "X-Username"
+	EmailHeader    = "X-Email"
+)
+
+var (
+	ErrDecode  = errors.New("failed to decode request")
+	ErrNoToken = errors.New("no bearer token")
+	ErrEncode  = errors.New("failed to encode response")
+
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+	// errorStatuses maps errors to a status and code, the first match winning.
+	errorStatuses = []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{ErrDecode, http.StatusBadRequest, entity.CodeInvalidRequest},
+		{ErrNoToken, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{endpoint.ErrInactiveToken, http.StatusForbidden, entity.CodeForbidden},
+		{endpoint.ErrRequest, http.StatusBadRequest, entity.CodeInvalidRequest},
+		{service.ErrTTL, http.StatusBadRequest, entity.CodeInvalidRequest},
+		{service.ErrTenant, http.StatusBadRequest, entity.CodeInvalidRequest},
+		{service.ErrReservedKey, http.StatusBadRequest, entity.CodeInvalidRequest},
+		{service.ErrTokenExpired, http.StatusUnauthorized, entity.CodeTokenExpired},
+		{service.ErrClaims, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{service.ErrUnexpectedSigningMethod, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{service.ErrUnknownKeyID, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{service.ErrTokenNotValidYet, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{service.ErrTokenType, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{service.ErrTenantMismatch, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{service.ErrNoSecret, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{jwt.ErrSignatureInvalid, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{jwt.ErrECDSAVerification, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{jwt.ErrEd25519Verification, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{rsa.ErrVerification, http.StatusUnauthorized, entity.CodeInvalidToken},
+		{service.ErrRefreshTokenInvalid, http.StatusUnauthorized, entity.CodeInvalidRefreshToken},
+		{service.ErrRefreshTokenReused, http.StatusUnauthorized, entity.CodeInvalidRefreshToken},
+		{service.ErrClientSecret, http.StatusForbidden, entity.CodeForbidden},
+		{service.ErrTooManySessions, http.StatusConflict, entity.CodeTooManySessions},
+		{store.ErrConflict, http.StatusConflict, entity.CodeConflict},
+		{store.ErrUnavailable, http.StatusServiceUnavailable, entity.CodeUnavailable},
+		{store.ErrClosed, http.StatusServiceUnavailable, entity.CodeUnavailable},
+	}
 )
 
 // DecodeRequest ...
 func DecodeRequest[req entity.IDUsernameEmailSecretRequest |
 	entity.TokenSecretRequest |
-	entity.Token](request req,
+	entity.Token |
+	entity.RefreshTokenRequest](request req,
 ) httptransport.DecodeRequestFunc {
 	return func(_ context.Context, r *http.Request) (any, error) {
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			return nil, fmt.Errorf("failed to decode request: %w", err)
+			return nil, fmt.Errorf("%w: %s", ErrDecode, err)
 		}
 
 		return request, nil
 	}
 }
 
+// DecodeTokenRequest decodes an entity.Token whose token, in order of
+// precedence, comes from TokenFromRequest or from the JSON body. The body is
+// optional then, but still read for the rest of the fields.
+func DecodeTokenRequest(cookie string) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (any, error) {
+		var request entity.Token
+
+		if err := decodeOptionalBody(r, &request); err != nil {
+			return nil, err
+		}
+
+		if token := TokenFromRequest(r, cookie); token != "" {
+			request.Token = token
+		}
+
+		return request, nil
+	}
+}
+
+// DecodeTokenSecretRequest is DecodeTokenRequest for the
+// entity.TokenSecretRequest of /extract.
+func DecodeTokenSecretRequest(cookie string) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (any, error) {
+		var request entity.TokenSecretRequest
+
+		if err := decodeOptionalBody(r, &request); err != nil {
+			return nil, err
+		}
+
+		if token := TokenFromRequest(r, cookie); token != "" {
+			request.Token = token
+		}
+
+		return request, nil
+	}
+}
+
+// DecodeAuthRequest decodes the forward-auth subrequest of a reverse proxy,
+// whose token only comes from TokenFromRequest.
+func DecodeAuthRequest(cookie string) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (any, error) {
+		token := TokenFromRequest(r, cookie)
+		if token == "" {
+			return nil, ErrNoToken
+		}
+
+		return entity.Token{Token: token}, nil
+	}
+}
+
+// EncodeAuthResponse answers a forward-auth subrequest with an empty 200 and
+// the identity headers the proxy injects into the upstream request.
+func EncodeAuthResponse(_ context.Context, w http.ResponseWriter, response any) error {
+	resp, ok := response.(entity.IDUsernameEmailErrResponse)
+	if !ok {
+		return fmt.Errorf("%w: isn't of type IDUsernameEmailErrResponse", ErrEncode)
+	}
+
+	w.Header().Set(UserIDHeader, strconv.Itoa(resp.ID))
+	w.Header().Set(UsernameHeader, resp.Username)
+	w.Header().Set(EmailHeader, resp.Email)
+	w.WriteHeader(http.StatusOK)
+
+	return nil
+}
+
+// TokenFromRequest returns the token of an "Authorization: Bearer" header or,
+// failing that, of the cookie named cookie, if any.
+func TokenFromRequest(r *http.Request, cookie string) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, bearerScheme) {
+		if token = strings.TrimSpace(token); token != "" {
+			return token
+		}
+	}
+
+	if cookie == "" {
+		return ""
+	}
+
+	if c, err := r.Cookie(cookie); err == nil {
+		return c.Value
+	}
+
+	return ""
+}
+
+// DecodeFormRequest decodes the application/x-www-form-urlencoded body used by
+// the OAuth 2.0 introspection and revocation endpoints.
+func DecodeFormRequest(_ context.Context, r *http.Request) (any, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, err)
+	}
+
+	return entity.TokenTypeHintRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+	}, nil
+}
+
 // EncodeResponse ...
 func EncodeResponse(_ context.Context, w http.ResponseWriter, response any) error {
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -33,3 +206,153 @@ func EncodeResponse(_ context.Context, w http.ResponseWriter, response any) erro
 
 	return nil
 }
+
+// EncodeJWKSResponse sets the JWK Set content type and lets clients cache the
+// key set for maxAge before fetching it again.
+func EncodeJWKSResponse(maxAge time.Duration) httptransport.EncodeResponseFunc {
+	return func(ctx context.Context, w http.ResponseWriter, response any) error {
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+
+		return EncodeResponse(ctx, w, response)
+	}
+}
+
+// ErrorEncoder answers a failed request with the status its error maps to
+// and an entity.ErrorEnvelope. Server errors do not expose their message,
+// which the error handler can log under the request id instead.
+func ErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	status, code := ErrorStatus(err)
+
+	message := err.Error()
+	if status >= http.StatusInternalServerError {
+		message = http.StatusText(status)
+	}
+
+	requestID := RequestIDFromContext(ctx)
+	if requestID != "" {
+		w.Header().Set(RequestIDHeader, requestID)
+	}
+
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(entity.ErrorEnvelope{Code: code, Message: message, RequestID: requestID})
+}
+
+// ErrorStatus returns the HTTP status and envelope code err maps to, 500 and
+// entity.CodeInternal for errors it does not know.
+func ErrorStatus(err error) (status int, code string) {
+	for _, mapping := range errorStatuses {
+		if errors.Is(err, mapping.err) {
+			return mapping.status, mapping.code
+		}
+	}
+
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) {
+		return http.StatusUnauthorized, entity.CodeInvalidToken
+	}
+
+	return http.StatusInternalServerError, entity.CodeInternal
+}
+
+// PopulateRequestID puts the request id in the context: the one the client
+// sent in RequestIDHeader if it looks sane, else a new one.
+func PopulateRequestID(ctx context.Context, r *http.Request) context.Context {
+	requestID := r.Header.Get(RequestIDHeader)
+	if !requestIDPattern.MatchString(requestID) {
+		requestID = uuid.NewString()
+	}
+
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// SetRequestIDHeader echoes the request id of successful responses;
+// ErrorEncoder does it for failed ones.
+func SetRequestIDHeader(ctx context.Context, w http.ResponseWriter) context.Context {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		w.Header().Set(RequestIDHeader, requestID)
+	}
+
+	return ctx
+}
+
+// RequestIDFromContext returns the request id PopulateRequestID stored, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+
+	return requestID
+}
+
+// TenantFromHeader puts the tenant named by the request header in the
+// context, where the endpoints scope the service to it.
+func TenantFromHeader(header string) httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if tenant := r.Header.Get(header); tenant != "" {
+			return service.ContextWithTenant(ctx, tenant)
+		}
+
+		return ctx
+	}
+}
+
+// DecodeUserIDRequest decodes the {id} path variable of the /users routes.
+func DecodeUserIDRequest(_ context.Context, r *http.Request) (any, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrDecode, err)
+	}
+
+	return entity.UserIDRequest{ID: id}, nil
+}
+
+// ClientFromRequest puts the user agent and address of the request in the
+// context, where the endpoints record them in the sessions they store. With
+// trustProxy the address is the first one of X-Forwarded-For, if any.
+func ClientFromRequest(trustProxy bool) httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		if forwarded := r.Header.Get("X-Forwarded-For"); trustProxy && forwarded != "" {
+			ip, _, _ = strings.Cut(forwarded, ",")
+			ip = strings.TrimSpace(ip)
+		}
+
+		return service.ContextWithClient(ctx, service.Client{UserAgent: r.UserAgent(), IP: ip})
+	}
+}
+
+// RequireBearerToken only lets requests through to next when their
+// Authorization header carries the bearer token expected, whatever the case
+// of the scheme.
+func RequireBearerToken(expected string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := TokenFromRequest(r, "")
+
+		if expected == "" || subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", bearerScheme)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeOptionalBody decodes the JSON body into v unless it is empty.
+func decodeOptionalBody(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s", ErrDecode, err)
+	}
+
+	return nil
+}
@@ -2,15 +2,41 @@ package transport_test
 
 import (
 	"bytes"
+	"cache/internal/endpoint"
 	"cache/internal/entity"
 	"cache/internal/entity/mock"
+	"cache/internal/pb"
+	"cache/internal/service"
+	"cache/internal/store"
 	"cache/internal/transport"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/go-redis/redis"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 const (
@@ -32,6 +58,11 @@ const (
 	tokenRequestJSON = `{
 		"token": "token"
 	}`
+
+	//nolint:gosec
+	refreshTokenRequestJSON = `{
+		"refresh_token": "token"
+	}`
 )
 
 func TestDecodeRequest(t *testing.T) {
@@ -64,6 +95,15 @@ func TestDecodeRequest(t *testing.T) {
 		assert.Error(t, err)
 	}
 
+	refreshTokenReq, err := http.NewRequest(
+		http.MethodPost,
+		mock.URLTest,
+		bytes.NewBuffer([]byte(refreshTokenRequestJSON)),
+	)
+	if err != nil {
+		assert.Error(t, err)
+	}
+
 	badReq, err := http.NewRequest(http.MethodPost, mock.URLTest, bytes.NewBuffer([]byte{}))
 	if err != nil {
 		assert.Error(t, err)
@@ -105,6 +145,13 @@ func TestDecodeRequest(t *testing.T) {
 			outToken: mock.TokenTest,
 			outErr:   "",
 		},
+		{
+			name:     mock.NameNoError + "RefreshToken",
+			inType:   entity.RefreshTokenRequest{},
+			in:       refreshTokenReq,
+			outToken: mock.TokenTest,
+			outErr:   "",
+		},
 		{
 			name:   "BadRequest",
 			inType: entity.IDUsernameEmailSecretRequest{},
@@ -159,6 +206,15 @@ func TestDecodeRequest(t *testing.T) {
 
 				assert.Equal(t, tt.outToken, result.Token)
 				assert.Contains(t, resultErr, tt.outErr)
+
+			case entity.RefreshTokenRequest:
+				req, err = transport.DecodeRequest(resultType)(context.TODO(), tt.in)
+
+				result, ok := req.(entity.RefreshTokenRequest)
+				assert.True(t, ok)
+
+				assert.Equal(t, tt.outToken, result.RefreshToken)
+				assert.Contains(t, resultErr, tt.outErr)
 			}
 		})
 	}
@@ -207,3 +263,943 @@ func TestEncodeResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeJWKSResponse(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name            string
+		in              any
+		inMaxAge        time.Duration
+		outCacheControl string
+		outErr          string
+	}{
+		{
+			name:            mock.NameNoError,
+			in:              entity.JWKSResponse{Keys: []map[string]string{}},
+			inMaxAge:        5 * time.Minute,
+			outCacheControl: "public, max-age=300",
+			outErr:          "",
+		},
+		{
+			name:            "ErrorBadEncode",
+			in:              func() {},
+			inMaxAge:        time.Minute,
+			outCacheControl: "public, max-age=60",
+			outErr:          "json: unsupported type: func()",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			w := httptest.NewRecorder()
+
+			err := transport.EncodeJWKSResponse(tt.inMaxAge)(context.TODO(), w, tt.in)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			if tt.name == mock.NameNoError {
+				assert.Empty(t, resultErr)
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+			}
+
+			assert.Equal(t, tt.outCacheControl, w.Header().Get("Cache-Control"))
+			assert.Equal(t, "application/jwk-set+json", w.Header().Get("Content-Type"))
+		})
+	}
+}
+
+func TestRequireBearerToken(t *testing.T) {
+	t.Parallel()
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, tt := range []struct {
+		name          string
+		inExpected    string
+		inHeader      string
+		outStatusCode int
+	}{
+		{
+			name:          mock.NameNoError,
+			inExpected:    mock.TokenTest,
+			inHeader:      "Bearer " + mock.TokenTest,
+			outStatusCode: http.StatusNoContent,
+		},
+		{
+			name:          "LowercaseScheme",
+			inExpected:    mock.TokenTest,
+			inHeader:      "bearer " + mock.TokenTest,
+			outStatusCode: http.StatusNoContent,
+		},
+		{
+			name:          "UppercaseScheme",
+			inExpected:    mock.TokenTest,
+			inHeader:      "BEARER " + mock.TokenTest,
+			outStatusCode: http.StatusNoContent,
+		},
+		{
+			name:          "ErrorNoScheme",
+			inExpected:    mock.TokenTest,
+			inHeader:      mock.TokenTest,
+			outStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "ErrorOtherScheme",
+			inExpected:    mock.TokenTest,
+			inHeader:      "Basic " + mock.TokenTest,
+			outStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "ErrorWrongToken",
+			inExpected:    mock.TokenTest,
+			inHeader:      "Bearer wrong",
+			outStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "ErrorNoHeader",
+			inExpected:    mock.TokenTest,
+			inHeader:      "",
+			outStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "ErrorNoExpected",
+			inExpected:    "",
+			inHeader:      "Bearer ",
+			outStatusCode: http.StatusUnauthorized,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/admin/keys/rotate", nil)
+
+			if tt.inHeader != "" {
+				r.Header.Set("Authorization", tt.inHeader)
+			}
+
+			transport.RequireBearerToken(tt.inExpected, next).ServeHTTP(w, r)
+
+			assert.Equal(t, tt.outStatusCode, w.Code)
+		})
+	}
+}
+
+func TestDecodeFormRequest(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name             string
+		inBody           string
+		inContentType    string
+		outToken         string
+		outTokenTypeHint string
+		outErr           string
+	}{
+		{
+			name:             mock.NameNoError,
+			inBody:           url.Values{"token": {mock.TokenTest}, "token_type_hint": {"access_token"}}.Encode(),
+			inContentType:    "application/x-www-form-urlencoded",
+			outToken:         mock.TokenTest,
+			outTokenTypeHint: "access_token",
+			outErr:           "",
+		},
+		{
+			name:          mock.NameNoError + "WithoutHint",
+			inBody:        url.Values{"token": {mock.TokenTest}}.Encode(),
+			inContentType: "application/x-www-form-urlencoded",
+			outToken:      mock.TokenTest,
+			outErr:        "",
+		},
+		{
+			name:          "BadRequest",
+			inBody:        "token=%zz",
+			inContentType: "application/x-www-form-urlencoded",
+			outErr:        "failed to decode request",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var resultErr string
+
+			r := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(tt.inBody))
+			r.Header.Set("Content-Type", tt.inContentType)
+
+			req, err := transport.DecodeFormRequest(context.TODO(), r)
+			if err != nil {
+				resultErr = err.Error()
+			}
+
+			if tt.outErr == "" {
+				assert.Empty(t, resultErr)
+			} else {
+				assert.Contains(t, resultErr, tt.outErr)
+
+				return
+			}
+
+			result, ok := req.(entity.TokenTypeHintRequest)
+			assert.True(t, ok)
+
+			assert.Equal(t, tt.outToken, result.Token)
+			assert.Equal(t, tt.outTokenTypeHint, result.TokenTypeHint)
+		})
+	}
+}
+
+func TestTenantFromHeader(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name      string
+		inHeader  string
+		outTenant string
+	}{
+		{
+			name:      mock.NameNoError,
+			inHeader:  "acme",
+			outTenant: "acme",
+		},
+		{
+			name:      "NoHeader",
+			inHeader:  "",
+			outTenant: "",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/check", nil)
+
+			if tt.inHeader != "" {
+				r.Header.Set("X-Tenant-ID", tt.inHeader)
+			}
+
+			ctx := transport.TenantFromHeader("X-Tenant-ID")(context.Background(), r)
+
+			assert.Equal(t, tt.outTenant, service.TenantFromContext(ctx))
+		})
+	}
+}
+
+func TestDecodeUserIDRequest(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name   string
+		inID   string
+		outErr string
+		outID  int
+	}{
+		{
+			name:  mock.NameNoError,
+			inID:  "42",
+			outID: 42,
+		},
+		{
+			name:   mock.NameErrorRequest,
+			inID:   "cesar",
+			outErr: "failed to decode request",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/users/"+tt.inID+"/tokens", nil),
+				map[string]string{"id": tt.inID})
+
+			result, err := transport.DecodeUserIDRequest(context.TODO(), r)
+			if tt.outErr != "" {
+				assert.ErrorContains(t, err, tt.outErr)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, entity.UserIDRequest{ID: tt.outID}, result)
+		})
+	}
+}
+
+func TestClientFromRequest(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name         string
+		inForwarded  string
+		outIP        string
+		inTrustProxy bool
+	}{
+		{
+			name:  mock.NameNoError,
+			outIP: "192.0.2.1",
+		},
+		{
+			name:         "TrustProxy",
+			inForwarded:  "198.51.100.7, 10.0.0.1",
+			inTrustProxy: true,
+			outIP:        "198.51.100.7",
+		},
+		{
+			name:        "UntrustedProxy",
+			inForwarded: "198.51.100.7",
+			outIP:       "192.0.2.1",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/token", nil)
+			r.Header.Set("User-Agent", "curl/8.0")
+
+			if tt.inForwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.inForwarded)
+			}
+
+			ctx := transport.ClientFromRequest(tt.inTrustProxy)(context.Background(), r)
+
+			assert.Equal(t, service.Client{UserAgent: "curl/8.0", IP: tt.outIP}, service.ClientFromContext(ctx))
+		})
+	}
+}
+
+func TestErrorEncoder(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		inErr      error
+		name       string
+		outCode    string
+		outMessage string
+		outStatus  int
+	}{
+		{
+			name:       "Decode",
+			inErr:      fmt.Errorf("%w: unexpected EOF", transport.ErrDecode),
+			outStatus:  http.StatusBadRequest,
+			outCode:    entity.CodeInvalidRequest,
+			outMessage: "failed to decode request: unexpected EOF",
+		},
+		{
+			name:       mock.NameErrorRequest,
+			inErr:      fmt.Errorf("%w: isn't of type Token", endpoint.ErrRequest),
+			outStatus:  http.StatusBadRequest,
+			outCode:    entity.CodeInvalidRequest,
+			outMessage: "error to request: isn't of type Token",
+		},
+		{
+			name:       "Claims",
+			inErr:      fmt.Errorf("error to extract token: %w", service.ErrClaims),
+			outStatus:  http.StatusUnauthorized,
+			outCode:    entity.CodeInvalidToken,
+			outMessage: "error to extract token: error to claims",
+		},
+		{
+			name:       "SigningMethod",
+			inErr:      service.ErrUnexpectedSigningMethod,
+			outStatus:  http.StatusUnauthorized,
+			outCode:    entity.CodeInvalidToken,
+			outMessage: service.ErrUnexpectedSigningMethod.Error(),
+		},
+		{
+			name:       "Malformed",
+			inErr:      jwt.NewValidationError("token contains an invalid number of segments", jwt.ValidationErrorMalformed),
+			outStatus:  http.StatusUnauthorized,
+			outCode:    entity.CodeInvalidToken,
+			outMessage: "token contains an invalid number of segments",
+		},
+		{
+			name:       "Signature",
+			inErr:      fmt.Errorf("error to extract token: %w", jwt.ErrECDSAVerification),
+			outStatus:  http.StatusUnauthorized,
+			outCode:    entity.CodeInvalidToken,
+			outMessage: "error to extract token: crypto/ecdsa: verification error",
+		},
+		{
+			name:       "Expired",
+			inErr:      service.ErrTokenExpired,
+			outStatus:  http.StatusUnauthorized,
+			outCode:    entity.CodeTokenExpired,
+			outMessage: service.ErrTokenExpired.Error(),
+		},
+		{
+			name:       "TooManySessions",
+			inErr:      service.ErrTooManySessions,
+			outStatus:  http.StatusConflict,
+			outCode:    entity.CodeTooManySessions,
+			outMessage: service.ErrTooManySessions.Error(),
+		},
+		{
+			name:       "Unavailable",
+			inErr:      fmt.Errorf("error to get token: %w", store.ErrUnavailable),
+			outStatus:  http.StatusServiceUnavailable,
+			outCode:    entity.CodeUnavailable,
+			outMessage: http.StatusText(http.StatusServiceUnavailable),
+		},
+		{
+			name:       "Unknown",
+			inErr:      errors.New("dial tcp 10.0.0.1:6379: connection refused"),
+			outStatus:  http.StatusInternalServerError,
+			outCode:    entity.CodeInternal,
+			outMessage: http.StatusText(http.StatusInternalServerError),
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/check", nil)
+			r.Header.Set(transport.RequestIDHeader, "req-1")
+
+			w := httptest.NewRecorder()
+
+			transport.ErrorEncoder(transport.PopulateRequestID(context.Background(), r), tt.inErr, w)
+
+			assert.Equal(t, tt.outStatus, w.Code)
+			assert.Equal(t, "req-1", w.Header().Get(transport.RequestIDHeader))
+
+			var envelope entity.ErrorEnvelope
+
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&envelope))
+			assert.Equal(t, entity.ErrorEnvelope{
+				Code:      tt.outCode,
+				Message:   tt.outMessage,
+				RequestID: "req-1",
+			}, envelope)
+		})
+	}
+}
+
+func TestPopulateRequestID(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name        string
+		inRequestID string
+		outKept     bool
+	}{
+		{
+			name:        mock.NameNoError,
+			inRequestID: "3f2a-9c.1_b",
+			outKept:     true,
+		},
+		{
+			name: "Missing",
+		},
+		{
+			name:        "Invalid",
+			inRequestID: "req\nforged: log line",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/check", nil)
+			r.Header.Set(transport.RequestIDHeader, tt.inRequestID)
+
+			ctx := transport.PopulateRequestID(context.Background(), r)
+			requestID := transport.RequestIDFromContext(ctx)
+
+			if tt.outKept {
+				assert.Equal(t, tt.inRequestID, requestID)
+			} else {
+				assert.Len(t, requestID, len("00000000-0000-0000-0000-000000000000"))
+			}
+
+			w := httptest.NewRecorder()
+			transport.SetRequestIDHeader(ctx, w)
+
+			assert.Equal(t, requestID, w.Header().Get(transport.RequestIDHeader))
+		})
+	}
+}
+
+// grpcClient serves svc over an in-memory gRPC connection.
+func grpcClient(t *testing.T, svc service.Service) pb.TokenClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer()
+	pb.RegisterTokenServer(server, transport.NewGRPCServer(svc,
+		grpctransport.ServerBefore(transport.RequestIDFromMetadata, transport.ClientFromMetadata),
+	))
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		server.Stop()
+	})
+
+	return pb.NewTokenClient(conn)
+}
+
+func tokenService(t *testing.T) (service.Service, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	t.Cleanup(mr.Close)
+
+	key, err := service.GenerateSigningKey(jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	db := store.NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+
+	return service.GetService(db, service.WithSigningKeys(key), service.WithMaxSessions(1, service.SessionLimitEvictOldest)), mr
+}
+
+func TestGRPCServer(t *testing.T) {
+	t.Parallel()
+
+	svc, mr := tokenService(t)
+	client := grpcClient(t, svc)
+	ctx := context.Background()
+
+	generated, err := client.Generate(ctx, &pb.GenerateRequest{
+		Id:       int64(mock.IDTest),
+		Username: mock.UsernameTest,
+		Email:    mock.EmailTest,
+	})
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	assert.NotEmpty(t, generated.GetToken())
+	assert.NotEmpty(t, generated.GetRefreshToken())
+	assert.Greater(t, generated.GetExp(), generated.GetIat())
+
+	extracted, err := client.Extract(ctx, &pb.ExtractRequest{Token: generated.GetToken()})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(mock.IDTest), extracted.GetId())
+	assert.Equal(t, mock.UsernameTest, extracted.GetUsername())
+	assert.Equal(t, mock.EmailTest, extracted.GetEmail())
+
+	set, err := client.SetToken(ctx, &pb.SetTokenRequest{Token: generated.GetToken(), Ttl: 60})
+	assert.NoError(t, err)
+	assert.Empty(t, set.GetEvicted())
+	assert.Equal(t, time.Minute, mr.TTL(generated.GetToken()))
+
+	checked, err := client.CheckToken(ctx, &pb.TokenRequest{Token: generated.GetToken()})
+	assert.NoError(t, err)
+	assert.True(t, checked.GetCheck())
+
+	// The second token evicts the first one, WithMaxSessions(1).
+	second, err := client.Generate(ctx, &pb.GenerateRequest{
+		Id:       int64(mock.IDTest),
+		Username: mock.UsernameTest,
+		Email:    mock.EmailTest,
+	})
+	assert.NoError(t, err)
+
+	set, err = client.SetToken(ctx, &pb.SetTokenRequest{Token: second.GetToken()})
+	assert.NoError(t, err)
+
+	if assert.Len(t, set.GetEvicted(), 1) {
+		assert.Equal(t, "access", set.GetEvicted()[0].GetType())
+		assert.NotEmpty(t, set.GetEvicted()[0].GetIp())
+	}
+
+	_, err = client.DeleteToken(ctx, &pb.TokenRequest{Token: second.GetToken()})
+	assert.NoError(t, err)
+
+	checked, err = client.CheckToken(ctx, &pb.TokenRequest{Token: second.GetToken()})
+	assert.NoError(t, err)
+	assert.False(t, checked.GetCheck())
+}
+
+func TestGRPCServerErrors(t *testing.T) {
+	t.Parallel()
+
+	svc, _ := tokenService(t)
+	client := grpcClient(t, svc)
+
+	token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	for _, tt := range []struct {
+		call      func(ctx context.Context) error
+		name      string
+		outReason string
+		outCode   codes.Code
+	}{
+		{
+			name: "InvalidToken",
+			call: func(ctx context.Context) error {
+				_, callErr := client.Extract(ctx, &pb.ExtractRequest{Token: "a.b.c"})
+
+				return callErr
+			},
+			outCode:   codes.Unauthenticated,
+			outReason: entity.CodeInvalidToken,
+		},
+		{
+			name: "ClientSecret",
+			call: func(ctx context.Context) error {
+				_, callErr := client.Extract(ctx, &pb.ExtractRequest{Token: token, Secret: mock.SecretTest})
+
+				return callErr
+			},
+			outCode:   codes.PermissionDenied,
+			outReason: entity.CodeForbidden,
+		},
+		{
+			name: "InvalidTTL",
+			call: func(ctx context.Context) error {
+				_, callErr := client.SetToken(ctx, &pb.SetTokenRequest{Token: token, Ttl: -1})
+
+				return callErr
+			},
+			outCode:   codes.InvalidArgument,
+			outReason: entity.CodeInvalidRequest,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := metadata.AppendToOutgoingContext(context.Background(), transport.RequestIDHeader, "req-"+tt.name)
+
+			st, ok := status.FromError(tt.call(ctx))
+			if !ok {
+				assert.Fail(t, "error is not a gRPC status")
+			}
+
+			assert.Equal(t, tt.outCode, st.Code())
+
+			var reason, requestID string
+
+			for _, detail := range st.Details() {
+				switch detail := detail.(type) {
+				case *errdetails.ErrorInfo:
+					reason = detail.GetReason()
+				case *errdetails.RequestInfo:
+					requestID = detail.GetRequestId()
+				}
+			}
+
+			assert.Equal(t, tt.outReason, reason)
+			assert.Equal(t, "req-"+tt.name, requestID)
+		})
+	}
+}
+
+func TestGRPCServerUnavailable(t *testing.T) {
+	t.Parallel()
+
+	svc, mr := tokenService(t)
+	client := grpcClient(t, svc)
+
+	token, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	mr.Close()
+
+	_, err = client.CheckToken(context.Background(), &pb.TokenRequest{Token: token})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, http.StatusText(http.StatusServiceUnavailable), status.Convert(err).Message())
+}
+
+func TestDecodeTokenRequest(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name         string
+		inBody       string
+		inAuth       string
+		inCookie     string
+		inCookieName string
+		outErr       string
+		outToken     string
+		outTTL       int64
+	}{
+		{
+			name:     "Body",
+			inBody:   `{"token":"body","ttl":60}`,
+			outToken: "body",
+			outTTL:   60,
+		},
+		{
+			name:     "Header",
+			inAuth:   "Bearer header",
+			outToken: "header",
+		},
+		{
+			name:     "HeaderCaseInsensitive",
+			inAuth:   "bearer header",
+			outToken: "header",
+		},
+		{
+			name:         "Cookie",
+			inCookie:     "cookie",
+			inCookieName: "token",
+			outToken:     "cookie",
+		},
+		{
+			name:     "CookieNotConfigured",
+			inCookie: "cookie",
+			outToken: "",
+		},
+		{
+			name:         "HeaderOverCookieOverBody",
+			inBody:       `{"token":"body","ttl":60}`,
+			inAuth:       "Bearer header",
+			inCookie:     "cookie",
+			inCookieName: "token",
+			outToken:     "header",
+			outTTL:       60,
+		},
+		{
+			name:         "CookieOverBody",
+			inBody:       `{"token":"body"}`,
+			inCookie:     "cookie",
+			inCookieName: "token",
+			outToken:     "cookie",
+		},
+		{
+			name:     "OtherScheme",
+			inBody:   `{"token":"body"}`,
+			inAuth:   "Basic dXNlcjpwYXNz",
+			outToken: "body",
+		},
+		{
+			name:   "ErrorBody",
+			inBody: `{"token":`,
+			inAuth: "Bearer header",
+			outErr: transport.ErrDecode.Error(),
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			newRequest := func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(tt.inBody))
+
+				if tt.inAuth != "" {
+					r.Header.Set("Authorization", tt.inAuth)
+				}
+
+				if tt.inCookie != "" {
+					r.AddCookie(&http.Cookie{Name: "token", Value: tt.inCookie})
+				}
+
+				return r
+			}
+
+			result, err := transport.DecodeTokenRequest(tt.inCookieName)(context.TODO(), newRequest())
+			if tt.outErr != "" {
+				assert.ErrorContains(t, err, tt.outErr)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, entity.Token{Token: tt.outToken, TTL: tt.outTTL}, result)
+
+			result, err = transport.DecodeTokenSecretRequest(tt.inCookieName)(context.TODO(), newRequest())
+			assert.NoError(t, err)
+
+			secretRequest, ok := result.(entity.TokenSecretRequest)
+			assert.True(t, ok)
+			assert.Equal(t, tt.outToken, secretRequest.Token)
+		})
+	}
+}
+
+// authProxy is a reverse proxy in front of upstream that, like nginx
+// auth_request, asks auth about every request and passes the identity
+// headers of its answer on.
+func authProxy(t *testing.T, auth, upstream string) *httptest.Server {
+	t.Helper()
+
+	target, err := url.Parse(upstream)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	identity := []string{transport.UserIDHeader, transport.UsernameHeader, transport.EmailHeader}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subrequest, reqErr := http.NewRequestWithContext(r.Context(), http.MethodGet, auth, nil)
+		if reqErr != nil {
+			http.Error(w, reqErr.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		subrequest.Header.Set("Authorization", r.Header.Get("Authorization"))
+		subrequest.Header.Set("Cookie", r.Header.Get("Cookie"))
+
+		resp, doErr := http.DefaultClient.Do(subrequest)
+		if doErr != nil {
+			http.Error(w, doErr.Error(), http.StatusBadGateway)
+
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			w.WriteHeader(resp.StatusCode)
+
+			return
+		}
+
+		for _, header := range identity {
+			r.Header.Set(header, resp.Header.Get(header))
+		}
+
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestForwardAuth(t *testing.T) {
+	t.Parallel()
+
+	svc, _ := tokenService(t)
+
+	auth := httptest.NewServer(httptransport.NewServer(
+		endpoint.MakeAuthEndpoint(svc),
+		transport.DecodeAuthRequest("session"),
+		transport.EncodeAuthResponse,
+		httptransport.ServerErrorEncoder(transport.ErrorEncoder),
+	))
+	t.Cleanup(auth.Close)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Upstream-User-Id", r.Header.Get(transport.UserIDHeader))
+		w.Header().Set("Upstream-Username", r.Header.Get(transport.UsernameHeader))
+		w.Header().Set("Upstream-Email", r.Header.Get(transport.EmailHeader))
+	}))
+	t.Cleanup(upstream.Close)
+
+	proxy := authProxy(t, auth.URL+"/auth", upstream.URL)
+
+	stored, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	if err = svc.ManageToken(service.NewSetTokenState(), stored); err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	notStored, _, _, err := svc.GenerateToken(mock.IDTest, mock.UsernameTest, mock.EmailTest, nil)
+	if err != nil {
+		assert.Fail(t, err.Error())
+	}
+
+	for _, tt := range []struct {
+		name      string
+		inAuth    string
+		inCookie  string
+		outStatus int
+	}{
+		{
+			name:      "Bearer",
+			inAuth:    "Bearer " + stored,
+			outStatus: http.StatusOK,
+		},
+		{
+			name:      "Cookie",
+			inCookie:  stored,
+			outStatus: http.StatusOK,
+		},
+		{
+			name:      "ErrorNoToken",
+			outStatus: http.StatusUnauthorized,
+		},
+		{
+			name:      "ErrorNotValidToken",
+			inAuth:    "Bearer a.b.c",
+			outStatus: http.StatusUnauthorized,
+		},
+		{
+			name:      "ErrorInactive",
+			inAuth:    "Bearer " + notStored,
+			outStatus: http.StatusForbidden,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, reqErr := http.NewRequestWithContext(context.Background(), http.MethodGet, proxy.URL+"/app", nil)
+			if reqErr != nil {
+				assert.Fail(t, reqErr.Error())
+			}
+
+			// Identity headers sent by the client itself must not get through.
+			r.Header.Set(transport.UserIDHeader, "999")
+
+			if tt.inAuth != "" {
+				r.Header.Set("Authorization", tt.inAuth)
+			}
+
+			if tt.inCookie != "" {
+				r.AddCookie(&http.Cookie{Name: "session", Value: tt.inCookie})
+			}
+
+			resp, doErr := http.DefaultClient.Do(r)
+			if doErr != nil {
+				assert.Fail(t, doErr.Error())
+
+				return
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.outStatus, resp.StatusCode)
+
+			if tt.outStatus != http.StatusOK {
+				assert.Empty(t, resp.Header.Get("Upstream-User-Id"))
+
+				return
+			}
+
+			assert.Equal(t, strconv.Itoa(mock.IDTest), resp.Header.Get("Upstream-User-Id"))
+			assert.Equal(t, mock.UsernameTest, resp.Header.Get("Upstream-Username"))
+			assert.Equal(t, mock.EmailTest, resp.Header.Get("Upstream-Email"))
+		})
+	}
+}
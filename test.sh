@@ -1,16 +1,50 @@
 #!/bin/bash
 
 # GenerateToken
-curl -XPOST -d'{"id":1,"username":"cesar","email":"cesar@email.com","secret":"secret"}' localhost:9090/generate
+curl -XPOST -d'{"id":1,"username":"cesar","email":"cesar@email.com"}' localhost:9090/generate
 
 # ExtractToken
-# curl -XPOST -d'{"token":"token","secret":"secret"}' localhost:9090/extract
+# curl -XPOST -d'{"token":"token"}' localhost:9090/extract
 
 # SetToken
 # curl -XPOST -d'{"token":"token"}' localhost:9090/token
+# curl -XPOST -d'{"token":"token","ttl":60}' localhost:9090/token
 
 # DeleteToken
 # curl -XDELETE -d'{"token":"token"}' localhost:9090/token
 
 # CheckToken
 # curl -XPOST -d'{"token":"token"}' localhost:9090/check
+# curl -XPOST -H'Authorization: Bearer token' localhost:9090/check
+# curl -XPOST --cookie 'token=token' localhost:9090/check (with TOKEN_COOKIE=token)
+
+# RefreshToken
+# curl -XPOST -d'{"refresh_token":"token"}' localhost:9090/refresh
+
+# IntrospectToken
+# curl -XPOST -d'token=token&token_type_hint=access_token' localhost:9090/introspect
+
+# RevokeToken
+# curl -XPOST -d'token=token&token_type_hint=refresh_token' localhost:9090/revoke
+
+# JWKS
+# curl localhost:9090/.well-known/jwks.json
+
+# RotateKeys (requires ADMIN_TOKEN)
+# curl -XPOST -H'Authorization: Bearer admin-token' localhost:9090/admin/keys/rotate
+
+# Tenants (TENANT_HEADER, X-Tenant-ID by default)
+# curl -XPOST -H'X-Tenant-ID: acme' -d'{"id":1,"username":"cesar","email":"cesar@email.com"}' localhost:9090/generate
+
+# Sessions of a user (requires ADMIN_TOKEN)
+# curl -H'Authorization: Bearer admin-token' localhost:9090/users/1/tokens
+# curl -XDELETE -H'Authorization: Bearer admin-token' localhost:9090/users/1/tokens
+
+# Errors answer with a status and {"code","message","request_id"}; X-Request-ID is echoed
+# curl -i -XPOST -H'X-Request-ID: my-request' -d'{"token":"token"}' localhost:9090/extract
+
+# gRPC (GRPC_PORT, 9091 by default)
+# grpcurl -plaintext -import-path internal/pb -proto token.proto -d '{"token":"token"}' localhost:9091 token.Token/CheckToken
+
+# Forward auth for nginx auth_request / Traefik forwardAuth: 200 with X-User-Id, X-Username and X-Email, else 401/403
+# curl -i -H'Authorization: Bearer token' localhost:9090/auth